@@ -0,0 +1,371 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the configuration of the API, see Load for where it comes from
+type Config struct {
+	Database   Database   `yaml:"database"`
+	HTTP       HTTP       `yaml:"http"`
+	Log        Log        `yaml:"log"`
+	Pagination Pagination `yaml:"pagination"`
+	Features   Features   `yaml:"features"`
+	Tracing    Tracing    `yaml:"tracing"`
+	Trending   Trending   `yaml:"trending"`
+}
+
+type Database struct {
+	DSN string `yaml:"dsn"`
+	// AutoMigrate applies the pending migrations before starting the server
+	AutoMigrate bool `yaml:"auto_migrate"`
+}
+
+type HTTP struct {
+	// Addr is the address the server listens on, e.g. ":8080" or "127.0.0.1:8080"
+	Addr string `yaml:"addr"`
+	// RequestTimeout is how long a request can take before a 504 is returned
+	RequestTimeout Duration `yaml:"request_timeout"`
+	// ReadHeaderTimeout, ReadTimeout, WriteTimeout and IdleTimeout protect the server against slow clients keeping
+	// connections open, see http.Server
+	ReadHeaderTimeout Duration `yaml:"read_header_timeout"`
+	ReadTimeout       Duration `yaml:"read_timeout"`
+	WriteTimeout      Duration `yaml:"write_timeout"`
+	IdleTimeout       Duration `yaml:"idle_timeout"`
+	// ShutdownTimeout is how long the in-flight requests are waited for when shutting down
+	ShutdownTimeout Duration `yaml:"shutdown_timeout"`
+}
+
+type Log struct {
+	// Level is one of debug, info, warn or error, the access log is written at the info level
+	Level string `yaml:"level"`
+}
+
+type Pagination struct {
+	// CursorSecret signs the pagination tokens, it must be the same across all the instances of the API.
+	// A random one is generated when empty.
+	CursorSecret string `yaml:"cursor_secret"`
+}
+
+// Features toggle the optional endpoints, the routes of a disabled feature return a 404
+type Features struct {
+	Search  bool `yaml:"search"`
+	Reposts bool `yaml:"reposts"`
+	Likes   bool `yaml:"likes"`
+	Edits   bool `yaml:"edits"`
+}
+
+// Tracing configures where the OpenTelemetry spans are exported
+type Tracing struct {
+	// Exporter is one of none, stdout (the JSON of the SDK, one span per line) or file (OTLP JSON)
+	Exporter string `yaml:"exporter"`
+	// File is the file the spans are appended to when Exporter is file
+	File string `yaml:"file"`
+}
+
+// Trending configures how the trending tags are scored and how often they're refreshed
+type Trending struct {
+	// Window is how far back the recent uses of a tag are counted, the older they are the less they weigh
+	Window Duration `yaml:"window"`
+	// Baseline is how far back the usual uses of a tag are counted, it must be longer than Window
+	Baseline Duration `yaml:"baseline"`
+	// Limit is the number of tags returned by default
+	Limit int `yaml:"limit"`
+	// RefreshInterval is how often the trending tags are computed again in the background
+	RefreshInterval Duration `yaml:"refresh_interval"`
+}
+
+// MaxTrendingLimit is the maximum number of trending tags that can be requested
+const MaxTrendingLimit = 100
+
+// Duration is a time.Duration written as a string (e.g. "30s") in the config file
+type Duration time.Duration
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+var logLevels = []string{"debug", "info", "warn", "error"}
+
+var tracingExporters = []string{"none", "stdout", "file"}
+
+// Default returns the configuration used when nothing else is supplied
+func Default() *Config {
+	return &Config{
+		Database: Database{DSN: "db.sqlite"},
+		HTTP: HTTP{
+			Addr:              ":8080",
+			RequestTimeout:    Duration(30 * time.Second),
+			ReadHeaderTimeout: Duration(5 * time.Second),
+			ReadTimeout:       Duration(10 * time.Second),
+			WriteTimeout:      Duration(35 * time.Second),
+			IdleTimeout:       Duration(2 * time.Minute),
+			ShutdownTimeout:   Duration(15 * time.Second),
+		},
+		Log:      Log{Level: "info"},
+		Features: Features{Search: true, Reposts: true, Likes: true, Edits: true},
+		Tracing:  Tracing{Exporter: "none"},
+		Trending: Trending{
+			Window:          Duration(24 * time.Hour),
+			Baseline:        Duration(7 * 24 * time.Hour),
+			Limit:           10,
+			RefreshInterval: Duration(time.Minute),
+		},
+	}
+}
+
+// Load builds the configuration on top of the defaults from, in order of precedence (the last one wins):
+// the YAML config file (-config flag or CONFIG_FILE environment variable), the environment variables and the
+// command line flags. The flags are defined on fs and args are parsed, so fs.Args() returns the remaining
+// arguments afterwards. The resulting configuration is validated.
+func Load(fs *flag.FlagSet, args []string, getenv func(string) string) (*Config, error) {
+	configFile := fs.String("config", "", "YAML config file (or CONFIG_FILE)")
+	dsn := fs.String("dsn", "", "SQLite DSN (or DB_DSN)")
+	autoMigrate := fs.Bool(
+		"auto-migrate", false, "apply the pending migrations before starting the server (or AUTO_MIGRATE)",
+	)
+	addr := fs.String("addr", "", "address to listen on, e.g. :8080 (or HTTP_ADDR, or HTTP_PORT for the port only)")
+	requestTimeout := fs.Duration("request-timeout", 0, "maximum duration of a request, e.g. 30s (or REQUEST_TIMEOUT)")
+	logLevel := fs.String("log-level", "", "one of "+strings.Join(logLevels, ", ")+" (or LOG_LEVEL)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+
+	if *configFile == "" {
+		*configFile = getenv("CONFIG_FILE")
+	}
+	if *configFile != "" {
+		if err := cfg.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(getenv); err != nil {
+		return nil, err
+	}
+
+	// only the flags that have been set override the configuration
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "dsn":
+			cfg.Database.DSN = *dsn
+		case "auto-migrate":
+			cfg.Database.AutoMigrate = *autoMigrate
+		case "addr":
+			cfg.HTTP.Addr = *addr
+		case "request-timeout":
+			cfg.HTTP.RequestTimeout = Duration(*requestTimeout)
+		case "log-level":
+			cfg.Log.Level = *logLevel
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %v", err)
+	}
+
+	// unknown keys are most likely typos, better to fail than to silently ignore them
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return fmt.Errorf("could not parse config file %q: %v", path, err)
+	}
+
+	return nil
+}
+
+func (c *Config) loadEnv(getenv func(string) string) error {
+	if v := getenv("DB_DSN"); v != "" {
+		c.Database.DSN = v
+	}
+	if v := getenv("HTTP_PORT"); v != "" {
+		// kept for backwards compatibility, HTTP_ADDR is more flexible
+		c.HTTP.Addr = ":" + v
+	}
+	if v := getenv("HTTP_ADDR"); v != "" {
+		c.HTTP.Addr = v
+	}
+	if v := getenv("LOG_LEVEL"); v != "" {
+		c.Log.Level = v
+	}
+	if v := getenv("CURSOR_SECRET"); v != "" {
+		c.Pagination.CursorSecret = v
+	}
+	if v := getenv("TRACING_EXPORTER"); v != "" {
+		c.Tracing.Exporter = v
+	}
+	if v := getenv("TRACING_FILE"); v != "" {
+		c.Tracing.File = v
+	}
+	if v := getenv("TRENDING_LIMIT"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid TRENDING_LIMIT %q, expected an integer", v)
+		}
+		c.Trending.Limit = limit
+	}
+
+	durations := map[string]*Duration{
+		"REQUEST_TIMEOUT":           &c.HTTP.RequestTimeout,
+		"HTTP_READ_HEADER_TIMEOUT":  &c.HTTP.ReadHeaderTimeout,
+		"HTTP_READ_TIMEOUT":         &c.HTTP.ReadTimeout,
+		"HTTP_WRITE_TIMEOUT":        &c.HTTP.WriteTimeout,
+		"HTTP_IDLE_TIMEOUT":         &c.HTTP.IdleTimeout,
+		"SHUTDOWN_TIMEOUT":          &c.HTTP.ShutdownTimeout,
+		"TRENDING_WINDOW":           &c.Trending.Window,
+		"TRENDING_BASELINE":         &c.Trending.Baseline,
+		"TRENDING_REFRESH_INTERVAL": &c.Trending.RefreshInterval,
+	}
+	for name, dst := range durations {
+		if v := getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q: %v", name, v, err)
+			}
+			*dst = Duration(d)
+		}
+	}
+
+	bools := map[string]*bool{
+		"AUTO_MIGRATE":    &c.Database.AutoMigrate,
+		"FEATURE_SEARCH":  &c.Features.Search,
+		"FEATURE_REPOSTS": &c.Features.Reposts,
+		"FEATURE_LIKES":   &c.Features.Likes,
+		"FEATURE_EDITS":   &c.Features.Edits,
+	}
+	for name, dst := range bools {
+		if v := getenv(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q, expected a boolean", name, v)
+			}
+			*dst = b
+		}
+	}
+
+	return nil
+}
+
+// Validate returns an error describing the first invalid setting, if any
+func (c *Config) Validate() error {
+	if c.Database.DSN == "" {
+		return fmt.Errorf("database DSN is required")
+	}
+
+	_, port, err := net.SplitHostPort(c.HTTP.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid HTTP address %q: %v", c.HTTP.Addr, err)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("invalid HTTP port %q, expected a number between 1 and 65535", port)
+	}
+
+	timeouts := []struct {
+		name  string
+		value Duration
+	}{
+		{"request timeout", c.HTTP.RequestTimeout},
+		{"read header timeout", c.HTTP.ReadHeaderTimeout},
+		{"read timeout", c.HTTP.ReadTimeout},
+		{"write timeout", c.HTTP.WriteTimeout},
+		{"idle timeout", c.HTTP.IdleTimeout},
+		{"shutdown timeout", c.HTTP.ShutdownTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value <= 0 {
+			return fmt.Errorf("%s must be positive, got %v", timeout.name, time.Duration(timeout.value))
+		}
+	}
+	if c.HTTP.WriteTimeout < c.HTTP.RequestTimeout {
+		// otherwise the connection would be closed before the 504 can be written
+		return fmt.Errorf(
+			"write timeout (%v) must not be shorter than the request timeout (%v)",
+			time.Duration(c.HTTP.WriteTimeout), time.Duration(c.HTTP.RequestTimeout),
+		)
+	}
+
+	if !contains(logLevels, c.Log.Level) {
+		return fmt.Errorf("invalid log level %q, expected one of %s", c.Log.Level, strings.Join(logLevels, ", "))
+	}
+
+	if !contains(tracingExporters, c.Tracing.Exporter) {
+		return fmt.Errorf(
+			"invalid tracing exporter %q, expected one of %s", c.Tracing.Exporter, strings.Join(tracingExporters, ", "),
+		)
+	}
+	if c.Tracing.Exporter == "file" && c.Tracing.File == "" {
+		return fmt.Errorf("tracing file is required by the file exporter")
+	}
+
+	if c.Trending.Window <= 0 || c.Trending.RefreshInterval <= 0 {
+		return fmt.Errorf("trending window and refresh interval must be positive")
+	}
+	if c.Trending.Baseline <= c.Trending.Window {
+		return fmt.Errorf(
+			"trending baseline (%v) must be longer than the window (%v)",
+			time.Duration(c.Trending.Baseline), time.Duration(c.Trending.Window),
+		)
+	}
+	if c.Trending.Limit < 1 || c.Trending.Limit > MaxTrendingLimit {
+		return fmt.Errorf("trending limit must be between 1 and %d, got %d", MaxTrendingLimit, c.Trending.Limit)
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String returns the configuration as YAML, secrets are masked
+func (c *Config) String() string {
+	masked := *c
+	if masked.Pagination.CursorSecret != "" {
+		masked.Pagination.CursorSecret = "********"
+	}
+
+	data, err := yaml.Marshal(&masked)
+	if err != nil {
+		return fmt.Sprintf("could not marshal config: %v", err)
+	}
+
+	return string(data)
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := load(t, nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, Default(), cfg)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	configFile := filepath.Join(dir, "config.yaml")
+	require.Nil(t, ioutil.WriteFile(configFile, []byte(`
+database:
+  dsn: file.sqlite
+http:
+  addr: ":9000"
+  request_timeout: 10s
+log:
+  level: warn
+features:
+  search: false
+tracing:
+  exporter: file
+  file: /tmp/spans.json
+trending:
+  window: 6h
+  limit: 20
+`), 0600))
+
+	cfg, err := load(t, []string{"-log-level", "debug", "migrate", "up"}, map[string]string{
+		"CONFIG_FILE":      configFile,
+		"HTTP_PORT":        "9001",
+		"LOG_LEVEL":        "error",
+		"FEATURE_LIKES":    "false",
+		"CURSOR_SECRET":    "secret",
+		"AUTO_MIGRATE":     "true",
+		"SHUTDOWN_TIMEOUT": "1s",
+		"TRACING_EXPORTER": "stdout",
+		"TRENDING_LIMIT":   "5",
+		"UNRELATED_VAR":    "ignored",
+	})
+	require.Nil(t, err)
+	require.Equal(t, &Config{
+		Database: Database{DSN: "file.sqlite", AutoMigrate: true}, // file
+		HTTP: HTTP{ // env over file
+			Addr:              ":9001",
+			RequestTimeout:    Duration(10 * time.Second),
+			ReadHeaderTimeout: Duration(5 * time.Second),
+			ReadTimeout:       Duration(10 * time.Second),
+			WriteTimeout:      Duration(35 * time.Second),
+			IdleTimeout:       Duration(2 * time.Minute),
+			ShutdownTimeout:   Duration(time.Second),
+		},
+		Log:        Log{Level: "debug"}, // flag over env
+		Pagination: Pagination{CursorSecret: "secret"},
+		Features:   Features{Search: false, Reposts: true, Likes: false, Edits: true},
+		Tracing:    Tracing{Exporter: "stdout", File: "/tmp/spans.json"},
+		Trending: Trending{
+			Window:          Duration(6 * time.Hour),
+			Baseline:        Duration(7 * 24 * time.Hour),
+			Limit:           5,
+			RefreshInterval: Duration(time.Minute),
+		},
+	}, cfg)
+
+	require.NotContains(t, cfg.String(), "cursor_secret: secret")
+	require.Contains(t, cfg.String(), "********")
+	require.Contains(t, cfg.String(), "request_timeout: 10s")
+
+	require.Nil(t, ioutil.WriteFile(configFile, []byte("http:\n  adr: \":9000\"\n"), 0600))
+	_, err = load(t, []string{"-config", configFile}, nil)
+	require.NotNil(t, err, "unknown keys must be rejected")
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	for _, env := range []map[string]string{
+		{"HTTP_PORT": "99999"},
+		{"HTTP_PORT": "abc"},
+		{"HTTP_ADDR": "localhost"},
+		{"REQUEST_TIMEOUT": "-1s"},
+		{"REQUEST_TIMEOUT": "soon"},
+		{"HTTP_IDLE_TIMEOUT": "0s"},
+		{"REQUEST_TIMEOUT": "1m", "HTTP_WRITE_TIMEOUT": "30s"},
+		{"LOG_LEVEL": "verbose"},
+		{"FEATURE_SEARCH": "maybe"},
+		{"TRACING_EXPORTER": "jaeger"},
+		{"TRACING_EXPORTER": "file"},
+		{"TRENDING_WINDOW": "0s"},
+		{"TRENDING_WINDOW": "192h"},
+		{"TRENDING_LIMIT": "101"},
+		{"TRENDING_LIMIT": "ten"},
+	} {
+		_, err := load(t, nil, env)
+		require.NotNil(t, err, "%v", env)
+	}
+}
+
+func load(t *testing.T, args []string, env map[string]string) (*Config, error) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+
+	return Load(fs, args, func(key string) string { return env[key] })
+}
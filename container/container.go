@@ -1,14 +1,23 @@
 package container
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
 	"fmt"
+	"go-twitter-test/config"
+	"go-twitter-test/logging"
+	"go-twitter-test/metrics"
+	"go-twitter-test/pagination"
 	"go-twitter-test/repositories/messages"
 	"go-twitter-test/repositories/tags"
 	"go-twitter-test/repositories/users"
 	"go-twitter-test/sqlite"
-	"log"
+	"go-twitter-test/tracing"
+	"go-twitter-test/trending"
+	"log/slog"
 	"os"
+	"time"
 )
 
 //go:generate counterfeiter . Container
@@ -16,15 +25,29 @@ type Container interface {
 	MessagesRepository() messages.Repository
 	UsersRepository() users.Repository
 	TagsRepository() tags.Repository
-	Logger() *log.Logger
+	Logger() *slog.Logger
+	CursorCodec() *pagination.Codec
+	Config() *config.Config
+	Metrics() *metrics.Metrics
+	// Ping checks that the database can be reached
+	Ping(ctx context.Context) error
+	// SchemaVersion returns the version the database schema is at, see sqlite.Version
+	SchemaVersion(ctx context.Context) (int, error)
+	// Close releases the resources held by the container, it must be called once the container isn't used anymore
+	Close() error
 }
 
 type container struct {
 	db                 *sql.DB
-	logger             *log.Logger
+	logger             *slog.Logger
 	messagesRepository messages.Repository
 	usersRepository    users.Repository
 	tagsRepository     tags.Repository
+	cursorCodec        *pagination.Codec
+	config             *config.Config
+	metrics            *metrics.Metrics
+	shutdownTracing    func(ctx context.Context) error
+	trending           *trending.Worker
 }
 
 func (c *container) MessagesRepository() messages.Repository {
@@ -39,21 +62,120 @@ func (c *container) TagsRepository() tags.Repository {
 	return c.tagsRepository
 }
 
-func (c *container) Logger() *log.Logger {
+func (c *container) Logger() *slog.Logger {
 	return c.logger
 }
 
-func NewContainer(sqliteDsn string) (Container, error) {
-	db, err := sqlite.New(sqliteDsn)
+func (c *container) CursorCodec() *pagination.Codec {
+	return c.cursorCodec
+}
+
+func (c *container) Config() *config.Config {
+	return c.config
+}
+
+func (c *container) Metrics() *metrics.Metrics {
+	return c.metrics
+}
+
+func (c *container) Ping(ctx context.Context) error {
+	return c.db.PingContext(ctx)
+}
+
+func (c *container) SchemaVersion(ctx context.Context) (int, error) {
+	return sqlite.Version(ctx, c.db)
+}
+
+func (c *container) Close() error {
+	// the worker must not use the db once it's closed
+	if c.trending != nil {
+		c.trending.Stop()
+	}
+
+	// flushing the spans that haven't been exported yet, they're lost otherwise
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.config.HTTP.ShutdownTimeout))
+	defer cancel()
+	tracingErr := c.shutdownTracing(ctx)
+
+	if err := c.db.Close(); err != nil {
+		return fmt.Errorf("container could not close db: %v", err)
+	}
+	if tracingErr != nil {
+		return fmt.Errorf("container could not shutdown tracing: %v", tracingErr)
+	}
+
+	return nil
+}
+
+// NewContainer initializes the container dependencies from the configuration
+func NewContainer(cfg *config.Config) (Container, error) {
+	logger := logging.New(os.Stdout, cfg.Log.Level)
+
+	// The cursor secret must be shared across all the instances behind a load balancer otherwise a token issued
+	// by one instance would be rejected by another one. A random one is fine for local development only.
+	cursorSecret := []byte(cfg.Pagination.CursorSecret)
+	if len(cursorSecret) == 0 {
+		cursorSecret = make([]byte, 32)
+		if _, err := rand.Read(cursorSecret); err != nil {
+			return nil, fmt.Errorf("container could not generate a random cursor secret: %v", err)
+		}
+		logger.Warn("No cursor secret supplied, pagination tokens won't survive a restart")
+	}
+
+	shutdownTracing, err := tracing.Setup(cfg.Tracing)
 	if err != nil {
+		return nil, fmt.Errorf("container could not setup tracing: %v", err)
+	}
+
+	// the tracer provider (and the file it may have opened) and the db are released if anything fails
+	db, err := sqlite.New(cfg.Database.DSN)
+	if err != nil {
+		_ = shutdownTracing(context.Background())
 		return nil, fmt.Errorf("container could not initialize db: %v", err)
 	}
 
-	return &container{
+	// better not to start at all than failing every write to the messages later on
+	if err := sqlite.CheckFTS5(context.Background(), db); err != nil {
+		_ = db.Close()
+		_ = shutdownTracing(context.Background())
+		return nil, fmt.Errorf("container could not use db: %v", err)
+	}
+
+	m := metrics.New()
+	if err := m.RegisterDB(db, "sqlite"); err != nil {
+		_ = db.Close()
+		_ = shutdownTracing(context.Background())
+		return nil, fmt.Errorf("container could not register db metrics: %v", err)
+	}
+
+	c := &container{
 		db:                 db,
-		logger:             log.New(os.Stdout, "", log.LstdFlags),
-		messagesRepository: messages.New(db),
-		usersRepository:    users.New(db),
-		tagsRepository:     tags.New(db),
-	}, nil
+		logger:             logger,
+		messagesRepository: m.MessagesRepository(messages.New(db)),
+		usersRepository:    m.UsersRepository(users.New(db)),
+		tagsRepository:     m.TagsRepository(tags.New(db)),
+		cursorCodec:        pagination.NewCodec(cursorSecret),
+		config:             cfg,
+		metrics:            m,
+		shutdownTracing:    shutdownTracing,
+	}
+
+	// the trending tags can't be refreshed before the migrations have been applied, the worker would fail every
+	// refresh interval otherwise. The readiness probe fails as well until then.
+	schemaVersion, err := sqlite.Version(context.Background(), db)
+	if err != nil {
+		_ = db.Close()
+		_ = shutdownTracing(context.Background())
+		return nil, fmt.Errorf("container could not get schema version: %v", err)
+	}
+	if schemaVersion >= sqlite.LatestVersion() {
+		c.trending = trending.Start(c.tagsRepository, cfg.Trending, logger)
+	} else {
+		logger.Warn(
+			"Database schema not up to date, the trending tags won't be refreshed until restarting after migrating",
+			"schema_version", schemaVersion, "latest_version", sqlite.LatestVersion(),
+		)
+	}
+
+	return c, nil
 }
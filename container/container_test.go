@@ -0,0 +1,36 @@
+package container
+
+import (
+	"go-twitter-test/config"
+	"go-twitter-test/sqlite"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewContainer_Trending(t *testing.T) {
+	const dbDsn = "./testdata/test1.db"
+	defer func() { require.Nil(t, os.Remove(dbDsn)) }()
+
+	cfg := config.Default()
+	cfg.Database.DSN = dbDsn
+	cfg.Log.Level = "error"
+
+	// the trending tags aren't refreshed until the schema is up to date
+	c, err := NewContainer(cfg)
+	require.Nil(t, err)
+	require.Nil(t, c.(*container).trending)
+	require.Nil(t, c.Close())
+
+	db, err := sqlite.New(dbDsn)
+	require.Nil(t, err)
+	_, err = sqlite.Migrate(db)
+	require.Nil(t, err)
+	require.Nil(t, db.Close())
+
+	c, err = NewContainer(cfg)
+	require.Nil(t, err)
+	require.NotNil(t, c.(*container).trending)
+	require.Nil(t, c.Close())
+}
@@ -1,17 +1,25 @@
 package mock
 
 import (
+	"go-twitter-test/config"
 	"go-twitter-test/container/containerfakes"
+	"go-twitter-test/metrics"
+	"go-twitter-test/pagination"
+	"go-twitter-test/sqlite"
 	"io/ioutil"
-	"log"
+	"log/slog"
 )
 
 func NewMockedContainer() *containerfakes.FakeContainer {
 	c := &containerfakes.FakeContainer{}
 	c.LoggerReturns(nullLogger())
+	c.CursorCodecReturns(pagination.NewCodec([]byte("test-secret")))
+	c.ConfigReturns(config.Default())
+	c.MetricsReturns(metrics.New())
+	c.SchemaVersionReturns(sqlite.LatestVersion(), nil)
 	return c
 }
 
-func nullLogger() *log.Logger {
-	return log.New(ioutil.Discard, "", log.LstdFlags)
+func nullLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
 }
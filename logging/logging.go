@@ -0,0 +1,37 @@
+// Package logging provides the structured logger of the API, which writes one JSON object per line with the time,
+// the level, the message and the fields of the entry
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+type ctxKey struct{}
+
+// New returns a logger writing the entries at or above level (debug, info, warn or error) to w.
+// An unknown level is treated as info, the configuration is validated beforehand anyway.
+func New(w io.Writer, level string) *slog.Logger {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		l = slog.LevelInfo
+	}
+
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: l}))
+}
+
+// NewContext returns a copy of ctx carrying the logger, see FromContext
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx (e.g. the one of a request, with its request ID), the default
+// logger if there's none
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
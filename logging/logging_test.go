@@ -0,0 +1,37 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "warn")
+
+	logger.Info("Not written")
+	logger.Warn("Written", "user_id", 42)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 1)
+
+	var entry map[string]interface{}
+	require.Nil(t, json.Unmarshal([]byte(lines[0]), &entry))
+	require.Equal(t, "WARN", entry["level"])
+	require.Equal(t, "Written", entry["msg"])
+	require.EqualValues(t, 42, entry["user_id"])
+	require.NotEmpty(t, entry["time"])
+}
+
+func TestFromContext(t *testing.T) {
+	require.Equal(t, slog.Default(), FromContext(context.Background()))
+
+	logger := New(&bytes.Buffer{}, "info")
+	require.Equal(t, logger, FromContext(NewContext(context.Background(), logger)))
+}
@@ -1,32 +1,66 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"go-twitter-test/config"
 	"go-twitter-test/container"
 	"go-twitter-test/routes"
 	"log"
-	"net/http"
 	"os"
-	"regexp"
-)
 
-const sqliteDsn = "db.sqlite" // @TODO make this one come from an environment variable
+	// the time zones of the tz parameter are embedded since the Docker image has no tzdata
+	_ "time/tzdata"
+)
 
 func main() {
-	// We could use a library to unmarshal env vars (e.g. Netflix/go-env) but since I'm doing custom validation on these
-	// and I only have two variables I decided to do it manually.
-	// If you end up having more than two environment variables may be worth using a library.
-	httpPortRe := "[0-9]{2,5}"
-	httpPort := os.Getenv("HTTP_PORT")
-	if !regexp.MustCompile(httpPortRe).MatchString(httpPort) {
-		log.Fatalf("Invalid HTTP port supplied %q (%v)", httpPort, httpPortRe)
+	printConfig := flag.Bool("print-config", false, "print the effective configuration and exit")
+	flag.Usage = func() {
+		_, _ = os.Stderr.WriteString("Usage: api [flags] [migrate up|down [steps]|version]\n")
+		flag.PrintDefaults()
 	}
 
-	c, err := container.NewContainer(sqliteDsn)
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], os.Getenv)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	if *printConfig {
+		fmt.Print(cfg)
+		return
+	}
+
+	if flag.Arg(0) == "migrate" {
+		if err := migrate(cfg.Database.DSN, flag.Args()[1:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	} else if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if cfg.Database.AutoMigrate {
+		if err := migrate(cfg.Database.DSN, []string{"up"}); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+	}
+
+	c, err := container.NewContainer(cfg)
 	if err != nil {
 		log.Fatalf("Could not initialize container: %v", err)
 	}
 
-	router := routes.NewRouter(c)
+	// the configuration is known from here on, the structured logger of the container replaces the standard one
+	logger := c.Logger()
+	serveErr := listenAndServe(cfg.HTTP, routes.NewRouter(c), logger)
+	if err := c.Close(); err != nil {
+		logger.Error("Could not close container", "error", err)
+	}
+	if serveErr != nil {
+		logger.Error("Server stopped", "error", serveErr)
+		os.Exit(1)
+	}
 
-	log.Fatal(http.ListenAndServe(":"+httpPort, router))
+	logger.Info("Server stopped")
 }
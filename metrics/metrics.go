@@ -0,0 +1,97 @@
+// Package metrics collects the Prometheus metrics of the API: the HTTP requests, the repository queries and the
+// database connection pool.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "twitter"
+
+// Metrics holds the collectors of the API, each instance has its own registry so that tests don't conflict
+type Metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	queryDuration   *prometheus.HistogramVec
+	queryErrors     *prometheus.CounterVec
+}
+
+// New returns the metrics of the API, the Go runtime and process metrics included
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of HTTP requests by route pattern, method and status code.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of the HTTP requests by route pattern, method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "repository",
+			Name:      "query_duration_seconds",
+			Help:      "Duration of the repository calls by repository and method.",
+			// the queries are way faster than the requests, DefBuckets starts at 5ms
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"repository", "method"}),
+		queryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "repository",
+			Name:      "errors_total",
+			Help:      "Number of failed repository calls by repository and method.",
+		}, []string{"repository", "method"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.queryDuration,
+		m.queryErrors,
+	)
+
+	return m
+}
+
+// RegisterDB exposes the sql.DBStats of db (open connections, waits etc.) as gauges and counters
+func (m *Metrics) RegisterDB(db *sql.DB, name string) error {
+	return m.registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler returns the handler exposing the metrics in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records an HTTP request, route must be a pattern (e.g. /v1/messages/{id}) and not the actual
+// path otherwise there would be a time series per message
+func (m *Metrics) ObserveRequest(route, method string, status int, duration time.Duration) {
+	statusCode := strconv.Itoa(status)
+	m.requests.WithLabelValues(route, method, statusCode).Inc()
+	m.requestDuration.WithLabelValues(route, method, statusCode).Observe(duration.Seconds())
+}
+
+// observeQuery records a repository call, it's meant to be deferred with the start time and the returned error.
+// sql.ErrNoRows is how the repositories tell that something doesn't exist, it isn't counted as an error.
+func (m *Metrics) observeQuery(repository, method string, start time.Time, err error) {
+	m.queryDuration.WithLabelValues(repository, method).Observe(time.Since(start).Seconds())
+	if err != nil && err != sql.ErrNoRows {
+		m.queryErrors.WithLabelValues(repository, method).Inc()
+	}
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"go-twitter-test/repositories/messages"
+	"go-twitter-test/repositories/messages/messagesfakes"
+	"go-twitter-test/repositories/tags/tagsfakes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_Repositories(t *testing.T) {
+	m := New()
+
+	messagesRepo := &messagesfakes.FakeRepository{}
+	messagesRepo.GetReturns(&messages.MessageList{ID: 1}, nil)
+	messagesRepo.DeleteReturns(messages.ErrNotAuthor)
+
+	repo := m.MessagesRepository(messagesRepo)
+
+	msg, err := repo.Get(context.Background(), 1, 2)
+	require.Nil(t, err)
+	require.Equal(t, int64(1), msg.ID)
+	_, msgID, viewerID := messagesRepo.GetArgsForCall(0)
+	require.Equal(t, int64(1), msgID)
+	require.Equal(t, int64(2), viewerID)
+
+	// the errors are returned as they are so that the callers can still compare them
+	require.Equal(t, messages.ErrNotAuthor, repo.Delete(context.Background(), 1, 2))
+
+	tagsRepo := &tagsfakes.FakeRepository{}
+	tagsRepo.GetIDReturns(0, sql.ErrNoRows)
+	_, err = m.TagsRepository(tagsRepo).GetID(context.Background(), "golang")
+	require.Equal(t, sql.ErrNoRows, err)
+
+	require.Equal(t, 3, testutil.CollectAndCount(m.queryDuration))
+	require.Equal(t, float64(0), testutil.ToFloat64(m.queryErrors.WithLabelValues("messages", "Get")))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.queryErrors.WithLabelValues("messages", "Delete")))
+	require.Equal(t, float64(0), testutil.ToFloat64(m.queryErrors.WithLabelValues("tags", "GetID")))
+
+	messagesRepo.CreateReturns(0, errors.New("some error"))
+	_, _ = repo.Create(context.Background(), messages.MessageCreate{})
+	require.Equal(t, float64(1), testutil.ToFloat64(m.queryErrors.WithLabelValues("messages", "Create")))
+}
+
+func TestMetrics_Handler(t *testing.T) {
+	m := New()
+	m.ObserveRequest("/v1/messages/{id}", "GET", 404, 10*time.Millisecond)
+
+	request, err := http.NewRequest("GET", "/metrics", nil)
+	require.Nil(t, err)
+
+	responseRecorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(responseRecorder, request)
+
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+	require.Contains(t, responseRecorder.Body.String(),
+		`twitter_http_requests_total{method="GET",route="/v1/messages/{id}",status="404"} 1`,
+	)
+	require.True(t, strings.Contains(responseRecorder.Body.String(), "go_goroutines"))
+}
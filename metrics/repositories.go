@@ -0,0 +1,220 @@
+package metrics
+
+import (
+	"context"
+	"go-twitter-test/pagination"
+	"go-twitter-test/repositories/messages"
+	"go-twitter-test/repositories/tags"
+	"go-twitter-test/repositories/users"
+	"time"
+)
+
+// MessagesRepository wraps repo so that the duration and the errors of its calls are recorded
+func (m *Metrics) MessagesRepository(repo messages.Repository) messages.Repository {
+	return &messagesRepository{repo: repo, metrics: m}
+}
+
+// UsersRepository wraps repo so that the duration and the errors of its calls are recorded
+func (m *Metrics) UsersRepository(repo users.Repository) users.Repository {
+	return &usersRepository{repo: repo, metrics: m}
+}
+
+// TagsRepository wraps repo so that the duration and the errors of its calls are recorded
+func (m *Metrics) TagsRepository(repo tags.Repository) tags.Repository {
+	return &tagsRepository{repo: repo, metrics: m}
+}
+
+type messagesRepository struct {
+	repo    messages.Repository
+	metrics *Metrics
+}
+
+func (r *messagesRepository) observe(method string, start time.Time, err error) {
+	r.metrics.observeQuery("messages", method, start, err)
+}
+
+func (r *messagesRepository) Create(ctx context.Context, msg messages.MessageCreate) (id int64, err error) {
+	defer func(start time.Time) { r.observe("Create", start, err) }(time.Now())
+	return r.repo.Create(ctx, msg)
+}
+
+func (r *messagesRepository) Get(ctx context.Context, msgID, viewerID int64) (msg *messages.MessageList, err error) {
+	defer func(start time.Time) { r.observe("Get", start, err) }(time.Now())
+	return r.repo.Get(ctx, msgID, viewerID)
+}
+
+func (r *messagesRepository) GetMessages(
+	ctx context.Context, filter messages.Filter, page pagination.Page,
+) (msgs *messages.MessagePage, err error) {
+	defer func(start time.Time) { r.observe("GetMessages", start, err) }(time.Now())
+	return r.repo.GetMessages(ctx, filter, page)
+}
+
+func (r *messagesRepository) CountMessages(ctx context.Context, filter messages.Filter) (count int64, err error) {
+	defer func(start time.Time) { r.observe("CountMessages", start, err) }(time.Now())
+	return r.repo.CountMessages(ctx, filter)
+}
+
+func (r *messagesRepository) GetStats(
+	ctx context.Context, query messages.StatsQuery,
+) (series []messages.Series, err error) {
+	defer func(start time.Time) { r.observe("GetStats", start, err) }(time.Now())
+	return r.repo.GetStats(ctx, query)
+}
+
+func (r *messagesRepository) Search(
+	ctx context.Context, query string, filter messages.Filter, limit int,
+) (results []messages.SearchResult, err error) {
+	defer func(start time.Time) { r.observe("Search", start, err) }(time.Now())
+	return r.repo.Search(ctx, query, filter, limit)
+}
+
+func (r *messagesRepository) GetThread(
+	ctx context.Context, msgID, viewerID int64,
+) (thread []messages.ThreadMessage, err error) {
+	defer func(start time.Time) { r.observe("GetThread", start, err) }(time.Now())
+	return r.repo.GetThread(ctx, msgID, viewerID)
+}
+
+func (r *messagesRepository) Repost(ctx context.Context, userID, msgID int64) (id int64, err error) {
+	defer func(start time.Time) { r.observe("Repost", start, err) }(time.Now())
+	return r.repo.Repost(ctx, userID, msgID)
+}
+
+func (r *messagesRepository) Unrepost(ctx context.Context, userID, msgID int64) (err error) {
+	defer func(start time.Time) { r.observe("Unrepost", start, err) }(time.Now())
+	return r.repo.Unrepost(ctx, userID, msgID)
+}
+
+func (r *messagesRepository) Like(ctx context.Context, userID, msgID int64) (err error) {
+	defer func(start time.Time) { r.observe("Like", start, err) }(time.Now())
+	return r.repo.Like(ctx, userID, msgID)
+}
+
+func (r *messagesRepository) Unlike(ctx context.Context, userID, msgID int64) (err error) {
+	defer func(start time.Time) { r.observe("Unlike", start, err) }(time.Now())
+	return r.repo.Unlike(ctx, userID, msgID)
+}
+
+func (r *messagesRepository) Update(ctx context.Context, msg messages.MessageUpdate) (err error) {
+	defer func(start time.Time) { r.observe("Update", start, err) }(time.Now())
+	return r.repo.Update(ctx, msg)
+}
+
+func (r *messagesRepository) CheckEditable(ctx context.Context, userID, msgID int64) (err error) {
+	defer func(start time.Time) { r.observe("CheckEditable", start, err) }(time.Now())
+	return r.repo.CheckEditable(ctx, userID, msgID)
+}
+
+func (r *messagesRepository) Delete(ctx context.Context, userID, msgID int64) (err error) {
+	defer func(start time.Time) { r.observe("Delete", start, err) }(time.Now())
+	return r.repo.Delete(ctx, userID, msgID)
+}
+
+func (r *messagesRepository) GetHistory(ctx context.Context, msgID int64) (revisions []messages.Revision, err error) {
+	defer func(start time.Time) { r.observe("GetHistory", start, err) }(time.Now())
+	return r.repo.GetHistory(ctx, msgID)
+}
+
+type usersRepository struct {
+	repo    users.Repository
+	metrics *Metrics
+}
+
+func (r *usersRepository) observe(method string, start time.Time, err error) {
+	r.metrics.observeQuery("users", method, start, err)
+}
+
+func (r *usersRepository) Get(ctx context.Context, userID int64) (user *users.User, err error) {
+	defer func(start time.Time) { r.observe("Get", start, err) }(time.Now())
+	return r.repo.Get(ctx, userID)
+}
+
+func (r *usersRepository) Create(ctx context.Context, user users.UserCreate) (id int64, err error) {
+	defer func(start time.Time) { r.observe("Create", start, err) }(time.Now())
+	return r.repo.Create(ctx, user)
+}
+
+func (r *usersRepository) Update(ctx context.Context, userID int64, update users.UserUpdate) (err error) {
+	defer func(start time.Time) { r.observe("Update", start, err) }(time.Now())
+	return r.repo.Update(ctx, userID, update)
+}
+
+func (r *usersRepository) Follow(ctx context.Context, followerID, followeeID int64) (err error) {
+	defer func(start time.Time) { r.observe("Follow", start, err) }(time.Now())
+	return r.repo.Follow(ctx, followerID, followeeID)
+}
+
+func (r *usersRepository) Unfollow(ctx context.Context, followerID, followeeID int64) (err error) {
+	defer func(start time.Time) { r.observe("Unfollow", start, err) }(time.Now())
+	return r.repo.Unfollow(ctx, followerID, followeeID)
+}
+
+func (r *usersRepository) GetFollowers(
+	ctx context.Context, userID int64, page pagination.Page,
+) (users *users.UserPage, err error) {
+	defer func(start time.Time) { r.observe("GetFollowers", start, err) }(time.Now())
+	return r.repo.GetFollowers(ctx, userID, page)
+}
+
+func (r *usersRepository) GetFollowing(
+	ctx context.Context, userID int64, page pagination.Page,
+) (users *users.UserPage, err error) {
+	defer func(start time.Time) { r.observe("GetFollowing", start, err) }(time.Now())
+	return r.repo.GetFollowing(ctx, userID, page)
+}
+
+type tagsRepository struct {
+	repo    tags.Repository
+	metrics *Metrics
+}
+
+func (r *tagsRepository) observe(method string, start time.Time, err error) {
+	r.metrics.observeQuery("tags", method, start, err)
+}
+
+func (r *tagsRepository) Put(ctx context.Context, tag string) (id int64, err error) {
+	defer func(start time.Time) { r.observe("Put", start, err) }(time.Now())
+	return r.repo.Put(ctx, tag)
+}
+
+func (r *tagsRepository) PutMany(ctx context.Context, tagList []string) (ids []int64, err error) {
+	defer func(start time.Time) { r.observe("PutMany", start, err) }(time.Now())
+	return r.repo.PutMany(ctx, tagList)
+}
+
+func (r *tagsRepository) GetID(ctx context.Context, tag string) (id int64, err error) {
+	defer func(start time.Time) { r.observe("GetID", start, err) }(time.Now())
+	return r.repo.GetID(ctx, tag)
+}
+
+func (r *tagsRepository) RefreshTrending(
+	ctx context.Context, now time.Time, window, baseline time.Duration,
+) (err error) {
+	defer func(start time.Time) { r.observe("RefreshTrending", start, err) }(time.Now())
+	return r.repo.RefreshTrending(ctx, now, window, baseline)
+}
+
+func (r *tagsRepository) GetTrending(ctx context.Context, limit int) (trending []tags.TrendingTag, err error) {
+	defer func(start time.Time) { r.observe("GetTrending", start, err) }(time.Now())
+	return r.repo.GetTrending(ctx, limit)
+}
+
+func (r *tagsRepository) List(
+	ctx context.Context, sort tags.Sort, page pagination.Page,
+) (list *tags.TagPage, err error) {
+	defer func(start time.Time) { r.observe("List", start, err) }(time.Now())
+	return r.repo.List(ctx, sort, page)
+}
+
+func (r *tagsRepository) Autocomplete(
+	ctx context.Context, prefix string, limit int,
+) (suggestions []tags.Tag, err error) {
+	defer func(start time.Time) { r.observe("Autocomplete", start, err) }(time.Now())
+	return r.repo.Autocomplete(ctx, prefix, limit)
+}
+
+func (r *tagsRepository) GetDetail(ctx context.Context, tag string, related int) (detail *tags.TagDetail, err error) {
+	defer func(start time.Time) { r.observe("GetDetail", start, err) }(time.Now())
+	return r.repo.GetDetail(ctx, tag, related)
+}
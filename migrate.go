@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go-twitter-test/sqlite"
+	"log"
+	"strconv"
+)
+
+// migrate runs the migrate sub-command: "up" applies all the pending migrations, "down [steps]" reverts the last
+// steps migrations (1 by default) and "version" prints the current schema version
+func migrate(sqliteDsn string, args []string) (err error) {
+	if len(args) == 0 {
+		return fmt.Errorf("missing migrate command, expected one of up, down or version")
+	}
+
+	db, err := sqlite.New(sqliteDsn)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := db.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("could not close db: %v", closeErr)
+		}
+	}()
+
+	switch args[0] {
+	case "up":
+		applied, err := sqlite.Migrate(db)
+		if err != nil {
+			return err
+		}
+		log.Printf("Applied %d migration(s)", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+
+		reverted, err := sqlite.Rollback(db, steps)
+		if err != nil {
+			return err
+		}
+		log.Printf("Reverted %d migration(s)", reverted)
+	case "version":
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected one of up, down or version", args[0])
+	}
+
+	version, err := sqlite.Version(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	log.Printf("Schema at version %d (latest is %d)", version, sqlite.LatestVersion())
+
+	return nil
+}
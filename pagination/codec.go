@@ -0,0 +1,86 @@
+package pagination
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// ErrInvalidToken is returned when a token is malformed or its signature doesn't match
+var ErrInvalidToken = errors.New("invalid pagination token")
+
+// Codec turns cursors into opaque tokens that can be handed to clients and vice versa.
+// Tokens are signed so that clients can't forge them to jump to arbitrary rows.
+type Codec struct {
+	secret []byte
+}
+
+type token struct {
+	CreatedAt int64  `json:"c"`
+	ID        int64  `json:"i"`
+	Backward  bool   `json:"b,omitempty"`
+	Sort      string `json:"s,omitempty"`
+	Key       string `json:"k,omitempty"`
+}
+
+// Encode returns the opaque token for the given cursor
+func (c *Codec) Encode(cursor Cursor) string {
+	payload, _ := json.Marshal(token{ // marshalling a struct of integers, strings and a bool can't fail
+		CreatedAt: cursor.CreatedAt,
+		ID:        cursor.ID,
+		Backward:  cursor.Backward,
+		Sort:      cursor.Sort,
+		Key:       cursor.Key,
+	})
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(c.sign(encoded))
+}
+
+// Decode verifies the token signature and returns the cursor it represents
+func (c *Codec) Decode(t string) (*Cursor, error) {
+	parts := strings.Split(t, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, c.sign(parts[0])) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var decoded token
+	if err := json.Unmarshal(payload, &decoded); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	return &Cursor{
+		CreatedAt: decoded.CreatedAt,
+		ID:        decoded.ID,
+		Backward:  decoded.Backward,
+		Sort:      decoded.Sort,
+		Key:       decoded.Key,
+	}, nil
+}
+
+func (c *Codec) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// NewCodec returns a codec that signs tokens with the given secret
+func NewCodec(secret []byte) *Codec {
+	return &Codec{
+		secret: secret,
+	}
+}
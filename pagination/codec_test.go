@@ -0,0 +1,34 @@
+package pagination
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodec(t *testing.T) {
+	codec := NewCodec([]byte("secret"))
+
+	cursor := Cursor{CreatedAt: 1567296000, ID: 123, Backward: true}
+	token := codec.Encode(cursor)
+
+	decoded, err := codec.Decode(token)
+	require.Nil(t, err)
+	require.Equal(t, &cursor, decoded)
+
+	sorted := Cursor{ID: 7, Sort: "name", Key: "golang"}
+	decoded, err = codec.Decode(codec.Encode(sorted))
+	require.Nil(t, err)
+	require.Equal(t, &sorted, decoded)
+
+	// tokens signed with a different secret must be rejected
+	_, err = NewCodec([]byte("another secret")).Decode(token)
+	require.Equal(t, ErrInvalidToken, err)
+
+	// tampered tokens must be rejected
+	_, err = codec.Decode(codec.Encode(Cursor{CreatedAt: 1, ID: 1}) + "x")
+	require.Equal(t, ErrInvalidToken, err)
+
+	_, err = codec.Decode("not-a-token")
+	require.Equal(t, ErrInvalidToken, err)
+}
@@ -0,0 +1,113 @@
+package pagination
+
+import "fmt"
+
+// DefaultLimit is the number of items returned per page when the client doesn't specify one
+const DefaultLimit = 20
+
+// MaxLimit is the maximum number of items a client can ask for in a single page
+const MaxLimit = 100
+
+// Cursor points to a row of a list sorted by creation time and ID (a.k.a. keyset pagination).
+// Keyset pagination is preferred over limit/offset because it doesn't skip or repeat rows when
+// new ones are inserted while a client is browsing back and forth through the list.
+// Lists sorted otherwise set Sort and Key instead of CreatedAt.
+type Cursor struct {
+	CreatedAt int64
+	ID        int64
+	// Backward is true when the cursor is used to get the page that precedes the row it points to
+	Backward bool
+	// Sort is the order of the list the cursor was issued for, empty when it's sorted by creation time.
+	// A cursor must be rejected by a list sorted differently since its key would be meaningless there.
+	Sort string
+	// Key is the sort key of the row when the list isn't sorted by creation time, e.g. the name of a tag
+	Key string
+}
+
+// Page describes which slice of a sorted list has to be returned.
+// A nil Cursor means the first page is requested.
+type Page struct {
+	Limit  int
+	Cursor *Cursor
+}
+
+// Condition returns the SQL condition (and its arguments) that selects the rows after (or before when going
+// backward) the cursor. An empty condition is returned if there's no cursor.
+func (p Page) Condition(createdAtColumn, idColumn string) (string, []interface{}) {
+	if p.Cursor == nil {
+		return "", nil
+	}
+
+	op := ">"
+	if p.Cursor.Backward {
+		op = "<"
+	}
+
+	condition := fmt.Sprintf(
+		"(%[1]s %[3]s ? OR (%[1]s = ? AND %[2]s %[3]s ?))",
+		createdAtColumn, idColumn, op,
+	)
+
+	return condition, []interface{}{p.Cursor.CreatedAt, p.Cursor.CreatedAt, p.Cursor.ID}
+}
+
+// OrderBy returns the ORDER BY and LIMIT clauses to be used along with Condition.
+// One more row than Limit is fetched so that Paginate can tell whether there are more pages.
+func (p Page) OrderBy(createdAtColumn, idColumn string) string {
+	direction := "ASC"
+	if p.Cursor != nil && p.Cursor.Backward {
+		direction = "DESC"
+	}
+
+	return fmt.Sprintf(
+		" ORDER BY %[1]s %[3]s, %[2]s %[3]s LIMIT %[4]d",
+		createdAtColumn, idColumn, direction, p.Limit+1,
+	)
+}
+
+// Paginate receives the keys of the fetched rows (in the order they were fetched) and returns the indexes of
+// the rows to return, in ascending order, along with the cursors pointing to the next and previous pages.
+// A nil cursor means there is no such page, an empty page has neither.
+func (p Page) Paginate(keys []Cursor) (indexes []int, next, prev *Cursor) {
+	hasMore := len(keys) > p.Limit
+	if hasMore {
+		keys = keys[:p.Limit]
+	}
+
+	backward := p.Cursor != nil && p.Cursor.Backward
+
+	indexes = make([]int, len(keys))
+	for i := range keys {
+		if backward {
+			indexes[i] = len(keys) - 1 - i
+		} else {
+			indexes[i] = i
+		}
+	}
+
+	// Nothing left in this direction (rows have been deleted since the cursor was issued). There's no cursor to go
+	// back with: the cursor row is excluded from both directions, so flipping it would skip that row, and the
+	// client still has the links of the page it came from.
+	if len(keys) == 0 {
+		return indexes, nil, nil
+	}
+
+	first, last := keys[indexes[0]], keys[indexes[len(indexes)-1]]
+	first.Backward, last.Backward = true, false
+
+	if backward {
+		next = &last
+		if hasMore {
+			prev = &first
+		}
+	} else {
+		if hasMore {
+			next = &last
+		}
+		if p.Cursor != nil {
+			prev = &first
+		}
+	}
+
+	return indexes, next, prev
+}
@@ -0,0 +1,32 @@
+package pagination
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPage_Paginate(t *testing.T) {
+	keys := []Cursor{{CreatedAt: 1, ID: 1}, {CreatedAt: 2, ID: 2}, {CreatedAt: 3, ID: 3}}
+
+	// first page, one more row than the limit means there's a next page
+	indexes, next, prev := Page{Limit: 2}.Paginate(keys)
+	require.Equal(t, []int{0, 1}, indexes)
+	require.Equal(t, &Cursor{CreatedAt: 2, ID: 2}, next)
+	require.Nil(t, prev)
+
+	// going backward the rows are fetched in reverse
+	cursor := &Cursor{CreatedAt: 4, ID: 4, Backward: true}
+	indexes, next, prev = Page{Limit: 2, Cursor: cursor}.Paginate([]Cursor{keys[2], keys[1], keys[0]})
+	require.Equal(t, []int{1, 0}, indexes)
+	require.Equal(t, &Cursor{CreatedAt: 3, ID: 3}, next)
+	require.Equal(t, &Cursor{CreatedAt: 2, ID: 2, Backward: true}, prev)
+
+	// an empty page doesn't link back to the cursor row, its cursor would skip it
+	for _, cursor := range []*Cursor{{CreatedAt: 3, ID: 3}, {CreatedAt: 1, ID: 1, Backward: true}} {
+		indexes, next, prev = Page{Limit: 2, Cursor: cursor}.Paginate(nil)
+		require.Empty(t, indexes)
+		require.Nil(t, next)
+		require.Nil(t, prev)
+	}
+}
@@ -0,0 +1,140 @@
+package parser
+
+import (
+	"strings"
+	"unicode"
+)
+
+// EntityType tells what kind of entity has been found in a text
+type EntityType string
+
+const (
+	// Hashtag is a word prefixed by a # (e.g. #golang)
+	Hashtag EntityType = "hashtag"
+	// Mention is a user handle prefixed by an @ (e.g. @gopher)
+	Mention EntityType = "mention"
+)
+
+// maxHandleLength is the maximum length of a handle, longer @words are not considered mentions
+const maxHandleLength = 15
+
+// Entity is a hashtag or a mention found in a text.
+// Offsets are expressed in Unicode code points (not bytes) so that clients can render links without having to
+// re-parse the text, Start points to the # or @ sign and End is exclusive.
+type Entity struct {
+	Type  EntityType `json:"type"`
+	Text  string     `json:"text"`
+	Start int        `json:"start"`
+	End   int        `json:"end"`
+}
+
+// Parse returns the hashtags and mentions found in text, in the order they appear.
+// The Text of an entity doesn't include the # or @ sign.
+func Parse(text string) []Entity {
+	var entities []Entity
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		if i > 0 && isWordRune(runes[i-1]) {
+			// e.g. "email@domain.com" or "C#" are neither mentions nor hashtags
+			continue
+		}
+
+		var entityType EntityType
+		var isValid func(rune) bool
+		switch runes[i] {
+		case '#', '＃':
+			entityType, isValid = Hashtag, isHashtagRune
+		case '@', '＠':
+			entityType, isValid = Mention, isHandleRune
+		default:
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && isValid(runes[end]) {
+			end++
+		}
+
+		word := runes[i+1 : end]
+		if entityType == Hashtag && !isValidHashtag(word) || entityType == Mention && !isValidHandle(word) {
+			i = end - 1
+			continue
+		}
+
+		entities = append(entities, Entity{
+			Type:  entityType,
+			Text:  string(word),
+			Start: i,
+			End:   end,
+		})
+		i = end - 1
+	}
+
+	return entities
+}
+
+// Hashtags returns the text of the hashtags among the given entities
+func Hashtags(entities []Entity) []string {
+	return filter(entities, Hashtag)
+}
+
+// Mentions returns the handles mentioned among the given entities, lower cased since handles are case insensitive
+func Mentions(entities []Entity) []string {
+	handles := filter(entities, Mention)
+	for i := range handles {
+		handles[i] = strings.ToLower(handles[i])
+	}
+
+	return handles
+}
+
+func filter(entities []Entity, entityType EntityType) []string {
+	var texts []string
+	for _, e := range entities {
+		if e.Type == entityType {
+			texts = append(texts, e.Text)
+		}
+	}
+
+	return texts
+}
+
+func isWordRune(r rune) bool {
+	return isHashtagRune(r) || r == '#' || r == '＃' || r == '@' || r == '＠'
+}
+
+// isHashtagRune allows letters and digits in any script (plus combining marks for scripts that rely on them)
+func isHashtagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == '_'
+}
+
+func isHandleRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_'
+}
+
+// isValidHashtag rejects empty and numbers only hashtags (e.g. #1 is usually not meant as a tag)
+func isValidHashtag(word []rune) bool {
+	for _, r := range word {
+		if !unicode.IsDigit(r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isValidHandle(word []rune) bool {
+	return len(word) > 0 && len(word) <= maxHandleLength
+}
+
+// ValidHandle tells whether handle can be mentioned, i.e. it's made of 1 to 15 ASCII letters, digits or underscores
+func ValidHandle(handle string) bool {
+	for _, r := range handle {
+		if !isHandleRune(r) {
+			return false
+		}
+	}
+
+	return isValidHandle([]rune(handle))
+}
@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected []Entity
+	}{
+		{
+			text:     "no entities here",
+			expected: nil,
+		},
+		{
+			text: "#golang is fun, ask @Gopher_1",
+			expected: []Entity{
+				{Type: Hashtag, Text: "golang", Start: 0, End: 7},
+				{Type: Mention, Text: "Gopher_1", Start: 20, End: 29},
+			},
+		},
+		{
+			// offsets are in code points, not bytes
+			text: "Καλημέρα #φιλότιμο!",
+			expected: []Entity{
+				{Type: Hashtag, Text: "φιλότιμο", Start: 9, End: 18},
+			},
+		},
+		{
+			text: "日本語 ＃東京 and #café",
+			expected: []Entity{
+				{Type: Hashtag, Text: "東京", Start: 4, End: 7},
+				{Type: Hashtag, Text: "café", Start: 12, End: 17},
+			},
+		},
+		{
+			// emails, numbers only hashtags, word suffixes and too long handles are ignored
+			text:     "mail me@example.com about C# or #123 @averyveryverylonghandle #",
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, Parse(test.text), test.text)
+	}
+}
+
+func TestHashtagsAndMentions(t *testing.T) {
+	entities := Parse("@Alice and @bob like #Go and #SQLite")
+
+	require.Equal(t, []string{"Go", "SQLite"}, Hashtags(entities))
+	require.Equal(t, []string{"alice", "bob"}, Mentions(entities))
+}
+
+func TestValidHandle(t *testing.T) {
+	require.True(t, ValidHandle("Gopher_1"))
+	require.False(t, ValidHandle(""))
+	require.False(t, ValidHandle("averyveryverylonghandle"))
+	require.False(t, ValidHandle("göpher"))
+	require.False(t, ValidHandle("@gopher"))
+}
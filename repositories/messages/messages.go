@@ -1,103 +1,555 @@
 package messages
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"go-twitter-test/pagination"
+	"go-twitter-test/parser"
+	"go-twitter-test/sqlite"
+	"go-twitter-test/tracing"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
+// ErrParentNotFound is returned when creating a reply to a message that does not exist
+var ErrParentNotFound = errors.New("message being replied to not found")
+
+// ErrQuotedNotFound is returned when creating a quote of a message that does not exist
+var ErrQuotedNotFound = errors.New("message being quoted not found")
+
+// ErrNotAuthor is returned when a user tries to edit or delete a message written by somebody else
+var ErrNotAuthor = errors.New("message written by another user")
+
+// ErrNotEditable is returned when editing a repost or a message older than EditWindow
+var ErrNotEditable = errors.New("message can't be edited")
+
+// EditWindow is how long after being created a message can still be edited
+const EditWindow = 15 * time.Minute
+
+// ErrSearchUnavailable is returned by Search when SQLite has been compiled without FTS5 (see the sqlite_fts5 tag)
+var ErrSearchUnavailable = errors.New("full-text search is not available")
+
+// ErrTooManyBuckets is returned by GetStats when the date range spans more than MaxBuckets intervals
+var ErrTooManyBuckets = errors.New("too many buckets")
+
+// MaxBuckets is the maximum number of buckets of the series returned by GetStats, e.g. about 41 days by hour
+const MaxBuckets = 1000
+
+// MaxSeries is the maximum StatsQuery.Limit, DefaultSeries the one used when the client doesn't set it
+const (
+	MaxSeries     = 50
+	DefaultSeries = 10
+)
+
 // Repository represents a contract for querying messages from an arbitrary data source
 //go:generate counterfeiter . Repository
 type Repository interface {
-	Create(msg MessageCreate) (int64, error)
-	GetMessages(tagID, dateStart, dateEnd int64) ([]MessageList, error)
-	CountMessages(tagID, dateStart, dateEnd int64) (int64, error)
+	Create(ctx context.Context, msg MessageCreate) (int64, error)
+	Get(ctx context.Context, msgID, viewerID int64) (*MessageList, error)
+	GetMessages(ctx context.Context, filter Filter, page pagination.Page) (*MessagePage, error)
+	CountMessages(ctx context.Context, filter Filter) (int64, error)
+	GetStats(ctx context.Context, query StatsQuery) ([]Series, error)
+	Search(ctx context.Context, query string, filter Filter, limit int) ([]SearchResult, error)
+	GetThread(ctx context.Context, msgID, viewerID int64) ([]ThreadMessage, error)
+	Repost(ctx context.Context, userID, msgID int64) (int64, error)
+	Unrepost(ctx context.Context, userID, msgID int64) error
+	Like(ctx context.Context, userID, msgID int64) error
+	Unlike(ctx context.Context, userID, msgID int64) error
+	Update(ctx context.Context, msg MessageUpdate) error
+	CheckEditable(ctx context.Context, userID, msgID int64) error
+	Delete(ctx context.Context, userID, msgID int64) error
+	GetHistory(ctx context.Context, msgID int64) ([]Revision, error)
 }
 
 type messagesRepository struct {
 	db *sql.DB
+
+	ftsOnce      sync.Once
+	ftsAvailable bool
+	ftsErr       error
 }
 
-func (r *messagesRepository) Create(msg MessageCreate) (int64, error) {
-	tx, err := r.db.Begin()
+func (r *messagesRepository) Create(ctx context.Context, msg MessageCreate) (msgID int64, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "messages", "Create")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return 0, fmt.Errorf("could not start transaction for creating a new message: %v", err)
 	}
 
-	res, err := tx.Exec(
-		"INSERT INTO messages (user_id, message, created_at) VALUES (?, ?, ?)",
-		msg.UserID, msg.Message, time.Now().Unix(), // https://www.sqlite.org/datatype3.html#datetime
+	// a reply belongs to the same conversation of the message it replies to
+	var inReplyTo, conversationID sql.NullInt64
+	if msg.InReplyTo != 0 {
+		inReplyTo = sql.NullInt64{Int64: msg.InReplyTo, Valid: true}
+		err = tx.QueryRowContext(
+			ctx, "SELECT conversation_id FROM messages WHERE id = ? AND deleted_at IS NULL", msg.InReplyTo,
+		).Scan(&conversationID)
+		if err == sql.ErrNoRows {
+			return 0, sqlite.RollbackTx(tx, ErrParentNotFound)
+		} else if err != nil {
+			return 0, sqlite.RollbackTx(tx, fmt.Errorf("could not get conversation of message %d: %v", msg.InReplyTo, err))
+		}
+	}
+
+	// quoting a repost means quoting the original message
+	var quoteOf sql.NullInt64
+	if msg.QuoteOf != 0 {
+		quoteOf.Valid = true
+		err = tx.QueryRowContext(
+			ctx, "SELECT COALESCE(repost_of, id) FROM messages WHERE id = ? AND deleted_at IS NULL", msg.QuoteOf,
+		).Scan(&quoteOf.Int64)
+		if err == sql.ErrNoRows {
+			return 0, sqlite.RollbackTx(tx, ErrQuotedNotFound)
+		} else if err != nil {
+			return 0, sqlite.RollbackTx(tx, fmt.Errorf("could not get quoted message %d: %v", msg.QuoteOf, err))
+		}
+	}
+
+	query := `INSERT INTO messages (user_id, message, created_at, in_reply_to, conversation_id, quote_of)
+		VALUES (?, ?, ?, ?, ?, ?)`
+	tracing.Statement(ctx, query)
+	res, err := tx.ExecContext(
+		ctx, query,
+		msg.UserID, msg.Message, time.Now().UnixMilli(), // https://www.sqlite.org/datatype3.html#datetime
+		inReplyTo, conversationID, quoteOf,
 	)
 	if err != nil {
-		return 0, fmt.Errorf("could not create message with user ID %d and message %q: %v", msg.UserID, msg.Message, err)
+		// the text is left out of the error since it ends up in the logs
+		return 0, sqlite.RollbackTx(tx, fmt.Errorf("could not create message for user ID %d: %v", msg.UserID, err))
 	}
 
-	msgID, err := res.LastInsertId()
+	msgID, err = res.LastInsertId()
 	if err != nil {
-		err = fmt.Errorf("could not get last inserted message ID: %v", err)
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			err = fmt.Errorf("could not rollback transaction (after %v): %v", err, rollbackErr)
+		return 0, sqlite.RollbackTx(tx, fmt.Errorf("could not get last inserted message ID: %v", err))
+	}
+
+	if !conversationID.Valid {
+		// the message starts a new conversation, which is identified by the message itself
+		if _, err = tx.ExecContext(ctx, "UPDATE messages SET conversation_id = id WHERE id = ?", msgID); err != nil {
+			return 0, sqlite.RollbackTx(tx, fmt.Errorf("could not set conversation of message %d: %v", msgID, err))
 		}
+	}
+
+	if err := linkEntities(ctx, tx, msgID, msg.TagIDs, msg.Mentions); err != nil {
+		return 0, sqlite.RollbackTx(tx, err)
+	}
 
-		return 0, err
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not commit transaction while creating new message: %v", err)
+	}
+
+	return msgID, nil
+}
+
+// Repost shares a message on behalf of the user and returns the ID of the repost.
+// Reposting a repost means reposting the original message, and reposting the same message twice returns the
+// existing repost. sql.ErrNoRows is returned if the message does not exist.
+func (r *messagesRepository) Repost(ctx context.Context, userID, msgID int64) (repostID int64, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "messages", "Repost")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not start transaction for reposting message %d: %v", msgID, err)
 	}
 
-	_, err = tx.Exec("INSERT INTO message_tag (message_id, tag_id) VALUES (?, ?)", msgID, msg.TagID)
+	var originalID int64
+	err = tx.QueryRowContext(
+		ctx, "SELECT COALESCE(repost_of, id) FROM messages WHERE id = ? AND deleted_at IS NULL", msgID,
+	).Scan(&originalID)
+	if err == sql.ErrNoRows {
+		return 0, sqlite.RollbackTx(tx, err)
+	} else if err != nil {
+		return 0, sqlite.RollbackTx(tx, fmt.Errorf("could not get message %d: %v", msgID, err))
+	}
+
+	// the unique index on user_id and repost_of makes reposts idempotent
+	query := `INSERT OR IGNORE INTO messages (user_id, message, created_at, repost_of) VALUES (?, '', ?, ?)`
+	tracing.Statement(ctx, query)
+	_, err = tx.ExecContext(
+		ctx, query,
+		userID, time.Now().UnixMilli(), originalID,
+	)
+	if err != nil {
+		return 0, sqlite.RollbackTx(tx, fmt.Errorf("could not repost message %d: %v", originalID, err))
+	}
+
+	err = tx.QueryRowContext(ctx, "SELECT id FROM messages WHERE user_id = ? AND repost_of = ?", userID, originalID).
+		Scan(&repostID)
+	if err != nil {
+		return 0, sqlite.RollbackTx(tx, fmt.Errorf("could not get repost of message %d: %v", originalID, err))
+	}
+
+	// a repost undone (or deleted by the reposter) is restored
+	_, err = tx.ExecContext(
+		ctx, "UPDATE messages SET deleted_at = NULL, created_at = ? WHERE id = ? AND deleted_at IS NOT NULL",
+		time.Now().UnixMilli(), repostID,
+	)
+	if err != nil {
+		return 0, sqlite.RollbackTx(tx, fmt.Errorf("could not restore repost %d: %v", repostID, err))
+	}
+
+	// reposts are not replies, each one is a conversation on its own like any other root message
+	_, err = tx.ExecContext(
+		ctx, "UPDATE messages SET conversation_id = id WHERE id = ? AND conversation_id IS NULL", repostID,
+	)
 	if err != nil {
-		err = fmt.Errorf("could not link tag to message: %v", err)
-		if rollbackErr := tx.Rollback(); rollbackErr != nil {
-			err = fmt.Errorf("could not rollback transaction (after %v): %v", err, rollbackErr)
+		return 0, sqlite.RollbackTx(tx, fmt.Errorf("could not set conversation of repost %d: %v", repostID, err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not commit transaction while reposting message %d: %v", originalID, err)
+	}
+
+	return repostID, nil
+}
+
+// Unrepost undoes the user's repost of a message (or of the original message if msgID is a repost), the repost is
+// soft deleted like Delete does so that the replies to the repost are not orphaned.
+// Undoing a repost that does not exist is a no-op.
+func (r *messagesRepository) Unrepost(ctx context.Context, userID, msgID int64) (err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "messages", "Unrepost")
+	defer func() { tracing.End(span, err) }()
+
+	query := `UPDATE messages SET deleted_at = ? WHERE user_id = ? AND deleted_at IS NULL AND repost_of = (
+		SELECT COALESCE(repost_of, id) FROM messages WHERE id = ?
+	)`
+	tracing.Statement(ctx, query)
+	if _, err = r.db.ExecContext(ctx, query, time.Now().UnixMilli(), userID, msgID); err != nil {
+		return fmt.Errorf("could not undo repost of message %d by user %d: %v", msgID, userID, err)
+	}
+
+	return nil
+}
+
+// Like makes the user like a message (or the original message if msgID is a repost), liking a message twice is
+// not an error. sql.ErrNoRows is returned if the message does not exist.
+func (r *messagesRepository) Like(ctx context.Context, userID, msgID int64) (err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "messages", "Like")
+	defer func() { tracing.End(span, err) }()
+
+	query := `INSERT OR IGNORE INTO likes (user_id, message_id, created_at)
+		SELECT ?, COALESCE(repost_of, id), ? FROM messages WHERE id = ? AND deleted_at IS NULL`
+	tracing.Statement(ctx, query)
+	res, err := r.db.ExecContext(
+		ctx, query,
+		userID, time.Now().UnixMilli(), msgID,
+	)
+	if err != nil {
+		return fmt.Errorf("could not like message %d by user %d: %v", msgID, userID, err)
+	}
+
+	if affected, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("could not get affected rows: %v", err)
+	} else if affected == 0 {
+		// either the message does not exist or it was already liked
+		var exists bool
+		err := r.db.QueryRowContext(
+			ctx, "SELECT EXISTS (SELECT 1 FROM messages WHERE id = ? AND deleted_at IS NULL)", msgID,
+		).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("could not check if message %d exists: %v", msgID, err)
+		}
+		if !exists {
+			return sql.ErrNoRows
 		}
+	}
+
+	return nil
+}
 
-		return 0, err
+// Unlike undoes the user's like of a message, unliking a message that is not liked is a no-op
+func (r *messagesRepository) Unlike(ctx context.Context, userID, msgID int64) (err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "messages", "Unlike")
+	defer func() { tracing.End(span, err) }()
+
+	query := `DELETE FROM likes WHERE user_id = ? AND message_id = (
+		SELECT COALESCE(repost_of, id) FROM messages WHERE id = ?
+	)`
+	tracing.Statement(ctx, query)
+	if _, err = r.db.ExecContext(ctx, query, userID, msgID); err != nil {
+		return fmt.Errorf("could not unlike message %d by user %d: %v", msgID, userID, err)
+	}
+
+	return nil
+}
+
+// Update edits the text of a message written by the user, replacing its tags and mentions. The previous text is
+// kept in the message revisions. sql.ErrNoRows is returned if the message does not exist, ErrNotAuthor if it
+// was written by somebody else and ErrNotEditable if it's a repost or it's older than EditWindow.
+func (r *messagesRepository) Update(ctx context.Context, msg MessageUpdate) (err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "messages", "Update")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not start transaction for updating message %d: %v", msg.ID, err)
+	}
+
+	previous, writtenAt, err := editable(ctx, tx, msg.UserID, msg.ID)
+	if err != nil {
+		return sqlite.RollbackTx(tx, err)
+	}
+
+	_, err = tx.ExecContext(
+		ctx, "INSERT INTO message_revisions (message_id, message, created_at) VALUES (?, ?, ?)",
+		msg.ID, previous, writtenAt,
+	)
+	if err != nil {
+		return sqlite.RollbackTx(tx, fmt.Errorf("could not create revision of message %d: %v", msg.ID, err))
+	}
+
+	query := "UPDATE messages SET message = ?, edited_at = ? WHERE id = ?"
+	tracing.Statement(ctx, query)
+	_, err = tx.ExecContext(ctx, query, msg.Message, time.Now().UnixMilli(), msg.ID)
+	if err != nil {
+		return sqlite.RollbackTx(tx, fmt.Errorf("could not update message %d: %v", msg.ID, err))
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM message_tag WHERE message_id = ?", msg.ID); err != nil {
+		return sqlite.RollbackTx(tx, fmt.Errorf("could not unlink tags of message %d: %v", msg.ID, err))
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM mentions WHERE message_id = ?", msg.ID); err != nil {
+		return sqlite.RollbackTx(tx, fmt.Errorf("could not unlink mentions of message %d: %v", msg.ID, err))
+	}
+	if err := linkEntities(ctx, tx, msg.ID, msg.TagIDs, msg.Mentions); err != nil {
+		return sqlite.RollbackTx(tx, err)
 	}
 
 	if err := tx.Commit(); err != nil {
-		return 0, fmt.Errorf("could not commit transaction while creating new message: %v", err)
+		return fmt.Errorf("could not commit transaction while updating message %d: %v", msg.ID, err)
 	}
 
-	return msgID, nil
+	return nil
+}
+
+// CheckEditable returns the error Update would return because of the message itself (sql.ErrNoRows, ErrNotAuthor or
+// ErrNotEditable), so that callers can find out before doing any work for the update
+func (r *messagesRepository) CheckEditable(ctx context.Context, userID, msgID int64) (err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "messages", "CheckEditable")
+	defer func() { tracing.End(span, err) }()
+
+	_, _, err = editable(ctx, r.db, userID, msgID)
+	return err
+}
+
+// rowQuerier is implemented by both sql.DB and sql.Tx
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// editable checks that the message can be edited by the user and returns its current text along with when it was
+// written, either when the message was created or when it was last edited
+func editable(ctx context.Context, q rowQuerier, userID, msgID int64) (text string, writtenAt int64, err error) {
+	var authorID, createdAt int64
+	var editedAt, repostOf sql.NullInt64
+	err = q.QueryRowContext(
+		ctx, "SELECT user_id, message, created_at, edited_at, repost_of FROM messages WHERE id = ? AND deleted_at IS NULL",
+		msgID,
+	).Scan(&authorID, &text, &createdAt, &editedAt, &repostOf)
+	if err == sql.ErrNoRows {
+		return "", 0, err
+	} else if err != nil {
+		return "", 0, fmt.Errorf("could not get message %d: %v", msgID, err)
+	}
+
+	if authorID != userID {
+		return "", 0, ErrNotAuthor
+	}
+	if repostOf.Valid || time.Since(time.UnixMilli(createdAt)) > EditWindow {
+		return "", 0, ErrNotEditable
+	}
+
+	writtenAt = createdAt
+	if editedAt.Valid {
+		writtenAt = editedAt.Int64
+	}
+
+	return text, writtenAt, nil
+}
+
+// Delete soft deletes a message written by the user along with its reposts, deleted messages are kept so that
+// the threads they belong to stay consistent. sql.ErrNoRows is returned if the message does not exist and
+// ErrNotAuthor if it was written by somebody else.
+func (r *messagesRepository) Delete(ctx context.Context, userID, msgID int64) (err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "messages", "Delete")
+	defer func() { tracing.End(span, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not start transaction for deleting message %d: %v", msgID, err)
+	}
+
+	var authorID int64
+	err = tx.QueryRowContext(ctx, "SELECT user_id FROM messages WHERE id = ? AND deleted_at IS NULL", msgID).Scan(&authorID)
+	if err == sql.ErrNoRows {
+		return sqlite.RollbackTx(tx, err)
+	} else if err != nil {
+		return sqlite.RollbackTx(tx, fmt.Errorf("could not get message %d: %v", msgID, err))
+	}
+
+	if authorID != userID {
+		return sqlite.RollbackTx(tx, ErrNotAuthor)
+	}
+
+	query := "UPDATE messages SET deleted_at = ? WHERE (id = ? OR repost_of = ?) AND deleted_at IS NULL"
+	tracing.Statement(ctx, query)
+	_, err = tx.ExecContext(ctx, query, time.Now().UnixMilli(), msgID, msgID)
+	if err != nil {
+		return sqlite.RollbackTx(tx, fmt.Errorf("could not delete message %d: %v", msgID, err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction while deleting message %d: %v", msgID, err)
+	}
+
+	return nil
 }
 
-func (r *messagesRepository) GetMessages(tagID, dateStart, dateEnd int64) ([]MessageList, error) {
-	rows, err := r.messagesQuery(false, tagID, dateStart, dateEnd)
+// GetHistory returns all the versions of the text of a message, the oldest first and the current one last.
+// sql.ErrNoRows is returned if the message does not exist.
+func (r *messagesRepository) GetHistory(ctx context.Context, msgID int64) (history []Revision, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "messages", "GetHistory")
+	defer func() { tracing.End(span, err) }()
+
+	var current string
+	var writtenAt int64
+	err = r.db.QueryRowContext(
+		ctx, "SELECT message, COALESCE(edited_at, created_at) FROM messages WHERE id = ? AND deleted_at IS NULL",
+		msgID,
+	).Scan(&current, &writtenAt)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT message, created_at FROM message_revisions WHERE message_id = ? ORDER BY id"
+	tracing.Statement(ctx, query)
+	rows, err := r.db.QueryContext(ctx, query, msgID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get revisions of message %d: %v", msgID, err)
+	}
+
+	for rows.Next() {
+		var revision Revision
+		var createdAt int64
+		if err := rows.Scan(&revision.Message, &createdAt); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("could not scan revision row: %v", err)
+		}
+
+		revision.CreatedAt = formatTime(createdAt)
+		history = append(history, revision)
+	}
+
+	// Next returns false on errors too, e.g. when the query is interrupted because the context is done
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %v", err)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("could not close rows: %v", err)
+	}
+
+	return append(history, Revision{
+		Message:   current,
+		CreatedAt: formatTime(writtenAt),
+	}), nil
+}
+
+// Get returns the message, its Liked flag is relative to the viewer (0 for anonymous viewers).
+// sql.ErrNoRows is returned if the message does not exist.
+func (r *messagesRepository) Get(ctx context.Context, msgID, viewerID int64) (msg *MessageList, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "messages", "Get")
+	defer func() { tracing.End(span, err) }()
+
+	query := "SELECT " + messageColumns + ` FROM messages AS m
+		INNER JOIN users AS u ON m.user_id = u.id
+		WHERE m.id = ? AND m.deleted_at IS NULL`
+	tracing.Statement(ctx, query)
+
+	msg, _, err = scanMessage(r.db.QueryRowContext(ctx, query, msgID))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.decorate(ctx, viewerID, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+func (r *messagesRepository) GetMessages(
+	ctx context.Context, filter Filter, page pagination.Page,
+) (result *MessagePage, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "messages", "GetMessages")
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := r.messagesQuery(ctx, false, filter, page)
 	if err != nil {
 		return nil, fmt.Errorf("could not get messages: %v", err)
 	}
 
 	var list []MessageList
+	var keys []pagination.Cursor
 	for rows.Next() {
-		msg := MessageList{}
-		var createdAt int64
-		if err := rows.Scan(&msg.ID, &msg.Message, &createdAt, &msg.UserEmail, &msg.Tag); err != nil {
+		msg, createdAt, err := scanMessage(rows)
+		if err != nil {
+			_ = rows.Close()
 			return nil, fmt.Errorf("could not scan message row: %v", err)
 		}
 
-		msg.CreatedAt = time.Unix(createdAt, 0).Format("2006-01-02T15:04:05")
-
-		list = append(list, msg)
+		list = append(list, *msg)
+		keys = append(keys, pagination.Cursor{CreatedAt: createdAt, ID: msg.ID})
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %v", err)
+	}
 	if err := rows.Close(); err != nil {
 		return nil, fmt.Errorf("could not close rows: %v", err)
 	}
 
-	return list, nil
+	indexes, next, prev := page.Paginate(keys)
+	result = &MessagePage{
+		Messages: make([]MessageList, 0, len(indexes)),
+		Next:     next,
+		Prev:     prev,
+	}
+	for _, i := range indexes {
+		result.Messages = append(result.Messages, list[i])
+	}
+
+	if err := r.decorate(ctx, filter.ViewerID, pointers(result.Messages)...); err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
 
-func (r *messagesRepository) CountMessages(tagID, dateStart, dateEnd int64) (int64, error) {
-	rows, err := r.messagesQuery(true, tagID, dateStart, dateEnd)
+// CountMessages counts the messages matching the filter, the reposts are not counted
+func (r *messagesRepository) CountMessages(ctx context.Context, filter Filter) (count int64, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "messages", "CountMessages")
+	defer func() { tracing.End(span, err) }()
+
+	rows, err := r.messagesQuery(ctx, true, filter, pagination.Page{})
 	if err != nil {
 		return 0, fmt.Errorf("could not count messages: %v", err)
 	}
 
-	var count int64
 	if rows.Next() {
 		if err := rows.Scan(&count); err != nil {
 			return 0, fmt.Errorf("could not scan message count: %v", err)
 		}
 	}
 
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("could not read rows: %v", err)
+	}
 	if err := rows.Close(); err != nil {
 		return 0, fmt.Errorf("could not close rows: %v", err)
 	}
@@ -105,33 +557,602 @@ func (r *messagesRepository) CountMessages(tagID, dateStart, dateEnd int64) (int
 	return count, nil
 }
 
-func (r *messagesRepository) messagesQuery(count bool, tagID, dateStart, dateEnd int64) (*sql.Rows, error) {
-	// this query without pagination could be dangerous, avoid in production
-	var args []interface{}
+// GetStats counts the messages (not the reposts) matching the filter of the query in consecutive buckets, the series
+// are sorted by key (the Other series last) and the empty buckets are zero-filled. ErrTooManyBuckets is returned if
+// there are more than MaxBuckets buckets.
+func (r *messagesRepository) GetStats(ctx context.Context, q StatsQuery) (series []Series, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "messages", "GetStats")
+	defer func() { tracing.End(span, err) }()
+
+	starts, err := buckets(q.Filter.DateStart, q.Filter.DateEnd, q.Interval, q.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	// the bounds of the buckets are computed here rather than in SQL so that they follow the time zone rules
+	// (e.g. a day can be 23 or 25 hours long when switching to or from daylight saving time)
+	values := make([]string, len(starts))
+	args := make([]interface{}, 0, 2*len(starts))
+	index := make(map[int64]int, len(starts))
+	for i, start := range starts {
+		end := q.Filter.DateEnd
+		if i < len(starts)-1 {
+			end = starts[i+1]
+		}
+
+		values[i] = "(?, ?)"
+		args = append(args, start.UnixMilli(), end.UnixMilli())
+		index[start.UnixMilli()] = i
+	}
+
+	keyColumn, joins := "''", ""
+	switch q.GroupBy {
+	case GroupByTag:
+		keyColumn = "t.tag"
+		joins = `INNER JOIN message_tag AS mt ON mt.message_id = m.id
+		INNER JOIN tags AS t ON t.id = mt.tag_id`
+	case GroupByUser:
+		keyColumn = "m.user_id"
+	}
 
+	conditions, conditionArgs := q.Filter.conditions()
+	args = append(args, conditionArgs...)
+
+	// a negative LIMIT is no limit at all
+	limit := q.Limit
+	if q.GroupBy == "" || limit <= 0 {
+		limit = -1
+	}
+	args = append(args, limit)
+
+	// the keys left out of top_keys are NULL after the LEFT JOIN, their counts are summed in the last series
+	query := "WITH buckets (start, end) AS (VALUES " + strings.Join(values, ", ") + `),
+		counts (start, series_key, count) AS (
+			SELECT b.start, ` + keyColumn + `, COUNT(*) FROM buckets AS b
+			INNER JOIN messages AS m ON m.created_at >= b.start AND m.created_at < b.end
+			` + joins + `
+			WHERE m.repost_of IS NULL` + conditions + `
+			GROUP BY b.start, ` + keyColumn + `
+		),
+		top_keys (series_key) AS (
+			SELECT series_key FROM counts GROUP BY series_key ORDER BY SUM(count) DESC, series_key LIMIT ?
+		)
+		SELECT c.start, k.series_key, SUM(c.count) FROM counts AS c
+		LEFT JOIN top_keys AS k ON k.series_key = c.series_key
+		GROUP BY c.start, k.series_key
+		ORDER BY k.series_key IS NULL, k.series_key, c.start`
+	tracing.Statement(ctx, query)
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not get message stats: %v", err)
+	}
+
+	series = []Series{}
+	for rows.Next() {
+		var start, count int64
+		var key sql.NullString
+		if err := rows.Scan(&start, &key, &count); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("could not scan message stats row: %v", err)
+		}
+
+		// the rows are sorted by key (the other keys last), a new key starts a new zero-filled series
+		other := !key.Valid
+		if len(series) == 0 || series[len(series)-1].Key != key.String || series[len(series)-1].Other != other {
+			series = append(series, newSeries(key.String, starts, q.Location))
+			series[len(series)-1].Other = other
+		}
+		series[len(series)-1].Points[index[start]].Count = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %v", err)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("could not close rows: %v", err)
+	}
+
+	// a single series is always returned when the messages are not grouped, even if there are none
+	if q.GroupBy == "" && len(series) == 0 {
+		series = append(series, newSeries("", starts, q.Location))
+	}
+
+	return series, nil
+}
+
+// buckets returns the start of the buckets of the interval from the one start falls in up to end (excluded)
+func buckets(start, end time.Time, interval Interval, loc *time.Location) ([]time.Time, error) {
+	if start.IsZero() || end.IsZero() {
+		return nil, fmt.Errorf("stats need both a start and an end date")
+	}
+
+	start = start.In(loc)
+	switch interval {
+	case Hour:
+		start = time.Date(start.Year(), start.Month(), start.Day(), start.Hour(), 0, 0, 0, loc)
+	case Day:
+		start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+	case Week:
+		// time.Sunday is 0, going back to the previous Monday
+		start = time.Date(start.Year(), start.Month(), start.Day()-(int(start.Weekday())+6)%7, 0, 0, 0, 0, loc)
+	default:
+		return nil, fmt.Errorf("unknown interval %q", interval)
+	}
+
+	var starts []time.Time
+	for t := start; t.Before(end); {
+		if len(starts) == MaxBuckets {
+			return nil, ErrTooManyBuckets
+		}
+		starts = append(starts, t)
+
+		switch interval {
+		case Hour:
+			// adding an hour to the instant rather than to the wall clock, which can repeat or skip an hour
+			t = t.Add(time.Hour)
+		case Day:
+			t = t.AddDate(0, 0, 1)
+		case Week:
+			t = t.AddDate(0, 0, 7)
+		}
+	}
+
+	return starts, nil
+}
+
+// newSeries returns a series with a zero point per bucket
+func newSeries(key string, starts []time.Time, loc *time.Location) Series {
+	series := Series{Key: key, Points: make([]Point, len(starts))}
+	for i, start := range starts {
+		series.Points[i].Start = start.In(loc).Format(TimeLayout)
+	}
+
+	return series
+}
+
+// GetThread returns the whole conversation the message belongs to, sorted for display: every message is
+// followed by its replies (depth first) and replies to the same message are sorted chronologically.
+// Deleted messages are returned as placeholders (see MessageList.Deleted) so that their replies are not orphaned.
+// sql.ErrNoRows is returned if the message does not exist.
+func (r *messagesRepository) GetThread(
+	ctx context.Context, msgID, viewerID int64,
+) (thread []ThreadMessage, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "messages", "GetThread")
+	defer func() { tracing.End(span, err) }()
+
+	var conversationID int64
+	err = r.db.QueryRowContext(ctx, "SELECT conversation_id FROM messages WHERE id = ?", msgID).Scan(&conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + messageColumns + ` FROM messages AS m
+		INNER JOIN users AS u ON m.user_id = u.id
+		WHERE m.conversation_id = ?
+		ORDER BY m.created_at, m.id`
+	tracing.Statement(ctx, query)
+	rows, err := r.db.QueryContext(ctx, query, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get conversation %d: %v", conversationID, err)
+	}
+
+	var root *MessageList
+	replies := make(map[int64][]*MessageList)
+	for rows.Next() {
+		msg, _, err := scanMessage(rows)
+		if err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("could not scan message row: %v", err)
+		}
+
+		if msg.InReplyTo == nil {
+			root = msg
+		} else {
+			replies[*msg.InReplyTo] = append(replies[*msg.InReplyTo], msg)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %v", err)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("could not close rows: %v", err)
+	}
+	if root == nil {
+		return nil, fmt.Errorf("conversation %d has no root message", conversationID)
+	}
+
+	all := make([]*MessageList, 0, len(replies)+1)
+	all = append(all, root)
+	for _, msgs := range replies {
+		all = append(all, msgs...)
+	}
+	if err := r.decorate(ctx, viewerID, all...); err != nil {
+		return nil, err
+	}
+
+	var walk func(msg *MessageList, depth int)
+	walk = func(msg *MessageList, depth int) {
+		thread = append(thread, ThreadMessage{MessageList: *msg, Depth: depth})
+		for _, reply := range replies[msg.ID] {
+			walk(reply, depth+1)
+		}
+	}
+	walk(root, 0)
+
+	return thread, nil
+}
+
+// Search returns the messages matching the full-text query, sorted by relevance (bm25).
+// Every word in the query must be present in the message text (prefixes are not matched).
+func (r *messagesRepository) Search(
+	ctx context.Context, query string, filter Filter, limit int,
+) (results []SearchResult, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "messages", "Search")
+	defer func() { tracing.End(span, err) }()
+
+	r.ftsOnce.Do(func() {
+		// not bound to the request context, the result is kept for good
+		r.ftsAvailable, r.ftsErr = sqlite.HasFTS5(context.Background(), r.db)
+	})
+	if r.ftsErr != nil {
+		return nil, fmt.Errorf("could not check if full-text search is available: %v", r.ftsErr)
+	}
+	if !r.ftsAvailable {
+		return nil, ErrSearchUnavailable
+	}
+
+	conditions, args := filter.conditions()
+	args = append([]interface{}{ftsQuery(query)}, args...)
+	args = append(args, limit)
+
+	statement := "SELECT " + messageColumns + `,
+			snippet(messages_fts, 0, '<mark>', '</mark>', '…', 16),
+			bm25(messages_fts) AS rank
+		FROM messages_fts
+		INNER JOIN messages AS m ON m.id = messages_fts.rowid
+		INNER JOIN users AS u ON m.user_id = u.id
+		WHERE messages_fts MATCH ?` + conditions + `
+		ORDER BY rank, m.id
+		LIMIT ?`
+	tracing.Statement(ctx, statement)
+	rows, err := r.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not search messages: %v", err)
+	}
+
+	results = []SearchResult{}
+	for rows.Next() {
+		var result SearchResult
+		msg, _, err := scanMessage(rows, &result.Snippet, &result.Rank)
+		if err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("could not scan search result row: %v", err)
+		}
+
+		result.MessageList = *msg
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %v", err)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("could not close rows: %v", err)
+	}
+
+	msgs := make([]*MessageList, len(results))
+	for i := range results {
+		msgs[i] = &results[i].MessageList
+	}
+	if err := r.decorate(ctx, filter.ViewerID, msgs...); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// ftsQuery turns the user input into a FTS5 query where every word is quoted, so that the FTS5 syntax
+// (e.g. AND, OR, NEAR, column filters) can't be injected and each word is matched as a plain term
+func ftsQuery(query string) string {
+	words := strings.Fields(query)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+
+	return strings.Join(words, " ")
+}
+
+// messagesQuery builds the query used to both list and count messages, the page is ignored when counting
+func (r *messagesRepository) messagesQuery(
+	ctx context.Context, count bool, filter Filter, page pagination.Page,
+) (*sql.Rows, error) {
 	query := "SELECT "
 	if count {
 		query += "COUNT(*)"
 	} else {
-		query += "m.id, m.message, m.created_at, u.email, t.tag"
+		query += messageColumns
 	}
 
 	query += ` FROM messages AS m
 		INNER JOIN users AS u ON m.user_id = u.id
-		INNER JOIN message_tag AS mt ON m.id = mt.message_id
-		INNER JOIN tags AS t ON mt.tag_id = t.id
 		WHERE 1 = 1`
 
-	if tagID != 0 {
-		query += " AND t.id = ?"
-		args = append(args, tagID)
+	conditions, args := filter.conditions()
+	query += conditions
+
+	if count {
+		// the reposts are listed (e.g. in the timeline) but they're not counted, they're not new messages
+		query += " AND m.repost_of IS NULL"
+	} else {
+		if condition, conditionArgs := page.Condition("m.created_at", "m.id"); condition != "" {
+			query += " AND " + condition
+			args = append(args, conditionArgs...)
+		}
+
+		query += page.OrderBy("m.created_at", "m.id")
 	}
-	if dateStart != 0 && dateEnd != 0 {
-		query += " AND m.created_at BETWEEN ? AND ?"
-		args = append(args, dateStart, dateEnd)
+
+	tracing.Statement(ctx, query)
+	return r.db.QueryContext(ctx, query, args...)
+}
+
+// conditions returns the SQL conditions (each one prefixed by AND) to filter the messages aliased as m,
+// deleted messages are always filtered out
+func (f Filter) conditions() (string, []interface{}) {
+	query := " AND m.deleted_at IS NULL"
+	var args []interface{}
+
+	if f.TagID != 0 {
+		// filtering with EXISTS rather than joining message_tag so that a message with many tags is counted once
+		query += " AND EXISTS (SELECT 1 FROM message_tag AS mt WHERE mt.message_id = m.id AND mt.tag_id = ?)"
+		args = append(args, f.TagID)
+	}
+	if !f.DateStart.IsZero() {
+		query += " AND m.created_at >= ?"
+		args = append(args, f.DateStart.UnixMilli())
+	}
+	if !f.DateEnd.IsZero() {
+		query += " AND m.created_at < ?"
+		args = append(args, f.DateEnd.UnixMilli())
+	}
+	if f.FollowedBy != 0 {
+		query += " AND m.user_id IN (SELECT f.followee_id FROM follows AS f WHERE f.follower_id = ?)"
+		args = append(args, f.FollowedBy)
+	}
+	if f.LikedBy != 0 {
+		query += " AND m.id IN (SELECT l.message_id FROM likes AS l WHERE l.user_id = ?)"
+		args = append(args, f.LikedBy)
+	}
+
+	return query, args
+}
+
+// messageColumns are the columns read by scanMessage, the tags are aggregated per message (separated by the
+// ASCII unit separator) so that a message with many tags is returned only once
+const messageColumns = `m.id, m.message, m.created_at, u.id, COALESCE(u.handle, ''), u.display_name,
+	(SELECT GROUP_CONCAT(t.tag, char(31)) FROM message_tag AS mt
+		INNER JOIN tags AS t ON mt.tag_id = t.id
+		WHERE mt.message_id = m.id),
+	m.in_reply_to, m.conversation_id,
+	(SELECT COUNT(*) FROM messages AS r WHERE r.in_reply_to = m.id AND r.deleted_at IS NULL),
+	m.repost_of, m.quote_of,
+	(SELECT COUNT(*) FROM messages AS rp WHERE rp.repost_of = m.id AND rp.deleted_at IS NULL),
+	(SELECT COUNT(*) FROM messages AS q WHERE q.quote_of = m.id AND q.deleted_at IS NULL),
+	(SELECT COUNT(*) FROM likes AS l WHERE l.message_id = m.id),
+	m.edited_at, m.deleted_at IS NOT NULL`
+
+const tagsSeparator = "\x1f"
+
+// TimeLayout is the RFC 3339 layout of the timestamps of the messages, always in UTC and with milliseconds
+const TimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// formatTime formats a timestamp as stored in the messages tables (unix milliseconds) with TimeLayout
+func formatTime(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(TimeLayout)
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanMessage scans a row selected with messageColumns, the raw creation time is returned as well for pagination.
+// Extra destinations can be passed for the columns selected after messageColumns.
+// The reposted and quoted messages only have their ID set until embedOriginals is called.
+func scanMessage(s scanner, extra ...interface{}) (*MessageList, int64, error) {
+	msg := MessageList{Tags: []string{}, Entities: []parser.Entity{}}
+	var createdAt int64
+	var tags sql.NullString
+	var inReplyTo, repostOf, quoteOf, editedAt sql.NullInt64
+	dest := append([]interface{}{
+		&msg.ID, &msg.Message, &createdAt, &msg.UserID, &msg.UserHandle, &msg.UserDisplayName, &tags, &inReplyTo,
+		&msg.ConversationID, &msg.ReplyCount, &repostOf, &quoteOf, &msg.RepostCount, &msg.QuoteCount, &msg.LikeCount,
+		&editedAt, &msg.Deleted,
+	}, extra...)
+	if err := s.Scan(dest...); err != nil {
+		return nil, 0, err
+	}
+
+	if inReplyTo.Valid {
+		msg.InReplyTo = &inReplyTo.Int64
+	}
+	// placeholders holding just the ID, see embedOriginals
+	if repostOf.Valid {
+		msg.RepostOf = &MessageList{ID: repostOf.Int64}
+	}
+	if quoteOf.Valid {
+		msg.QuoteOf = &MessageList{ID: quoteOf.Int64}
+	}
+
+	if msg.Deleted {
+		// a deleted message is just a placeholder keeping its thread together
+		msg.Message, msg.RepostOf, msg.QuoteOf = "", nil, nil
+		tags.Valid = false
+	}
+
+	msg.CreatedAt = formatTime(createdAt)
+	if editedAt.Valid {
+		editedAtString := formatTime(editedAt.Int64)
+		msg.EditedAt = &editedAtString
+	}
+	if tags.Valid && tags.String != "" {
+		msg.Tags = strings.Split(tags.String, tagsSeparator)
+		sort.Strings(msg.Tags)
+	}
+	if entities := parser.Parse(msg.Message); entities != nil {
+		msg.Entities = entities
+	}
+
+	return &msg, createdAt, nil
+}
+
+// decorate embeds the reposted and quoted messages and sets the Liked flag of all the messages (the embedded ones
+// included) relative to the viewer, no message is liked by anonymous viewers (0)
+func (r *messagesRepository) decorate(ctx context.Context, viewerID int64, msgs ...*MessageList) error {
+	if err := r.embedOriginals(ctx, msgs...); err != nil {
+		return err
+	}
+	if viewerID == 0 {
+		return nil
+	}
+
+	// the same message can be embedded many times (e.g. reposted and quoted), hence the slice of pointers
+	byID := make(map[int64][]*MessageList)
+	for _, msg := range msgs {
+		for _, m := range []*MessageList{msg, msg.RepostOf, msg.QuoteOf} {
+			if m != nil {
+				byID[m.ID] = append(byID[m.ID], m)
+			}
+		}
+	}
+	if len(byID) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, 0, len(byID))
+	args := make([]interface{}, 0, len(byID)+1)
+	args = append(args, viewerID)
+	for id := range byID {
+		placeholders = append(placeholders, "?")
+		args = append(args, id)
+	}
+
+	rows, err := r.db.QueryContext(
+		ctx, "SELECT message_id FROM likes WHERE user_id = ? AND message_id IN ("+strings.Join(placeholders, ", ")+")",
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("could not get likes of user %d: %v", viewerID, err)
+	}
+
+	for rows.Next() {
+		var msgID int64
+		if err := rows.Scan(&msgID); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("could not scan like row: %v", err)
+		}
+
+		for _, msg := range byID[msgID] {
+			msg.Liked = true
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not read rows: %v", err)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("could not close rows: %v", err)
+	}
+
+	return nil
+}
+
+// embedOriginals replaces the reposted and quoted messages placeholders set by scanMessage with the actual
+// messages, loaded with a single query. The embedded messages don't embed their own originals and the deleted
+// ones are not embedded at all.
+func (r *messagesRepository) embedOriginals(ctx context.Context, msgs ...*MessageList) error {
+	var placeholders []string
+	var args []interface{}
+	for _, msg := range msgs {
+		for _, original := range []*MessageList{msg.RepostOf, msg.QuoteOf} {
+			if original != nil {
+				placeholders = append(placeholders, "?")
+				args = append(args, original.ID)
+			}
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	rows, err := r.db.QueryContext(
+		ctx, "SELECT "+messageColumns+` FROM messages AS m
+		INNER JOIN users AS u ON m.user_id = u.id
+		WHERE m.deleted_at IS NULL AND m.id IN (`+strings.Join(placeholders, ", ")+")",
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("could not get reposted and quoted messages: %v", err)
+	}
+
+	originals := make(map[int64]*MessageList)
+	for rows.Next() {
+		original, _, err := scanMessage(rows)
+		if err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("could not scan message row: %v", err)
+		}
+
+		original.RepostOf, original.QuoteOf = nil, nil
+		originals[original.ID] = original
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not read rows: %v", err)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("could not close rows: %v", err)
+	}
+
+	for _, msg := range msgs {
+		if msg.RepostOf != nil {
+			msg.RepostOf = originals[msg.RepostOf.ID]
+		}
+		if msg.QuoteOf != nil {
+			msg.QuoteOf = originals[msg.QuoteOf.ID]
+		}
+	}
+
+	return nil
+}
+
+// pointers returns pointers to the messages of the slice so that they can be modified in place
+func pointers(msgs []MessageList) []*MessageList {
+	result := make([]*MessageList, len(msgs))
+	for i := range msgs {
+		result[i] = &msgs[i]
+	}
+
+	return result
+}
+
+// linkEntities links the tags and the mentions to the message
+func linkEntities(ctx context.Context, tx *sql.Tx, msgID int64, tagIDs []int64, mentions []string) error {
+	for _, tagID := range tagIDs {
+		_, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO message_tag (message_id, tag_id) VALUES (?, ?)", msgID, tagID)
+		if err != nil {
+			return fmt.Errorf("could not link tag %d to message: %v", tagID, err)
+		}
+	}
+
+	for _, handle := range mentions {
+		_, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO mentions (message_id, handle) VALUES (?, ?)", msgID, handle)
+		if err != nil {
+			return fmt.Errorf("could not link mention %q to message: %v", handle, err)
+		}
 	}
 
-	return r.db.Query(query, args...)
+	return nil
 }
 
 func New(db *sql.DB) Repository {
@@ -1,8 +1,12 @@
 package messages
 
 import (
+	"context"
 	"database/sql"
+	"go-twitter-test/pagination"
+	"go-twitter-test/parser"
 	"go-twitter-test/repositories/testutils"
+	"go-twitter-test/sqlite"
 	"testing"
 	"time"
 
@@ -16,131 +20,772 @@ func TestMessagesRepository(t *testing.T) {
 
 	loadFixtures(t, db)
 
-	now := time.Now()
-	dateStart := now.Unix()
-	dateEnd := now.Add(1 * time.Second).Unix()
-	formattedDateStart := now.Format("2006-01-02T15:04:05")
+	// the creation times are pinned once the messages have been created, see below
+	now := time.Date(2019, 9, 1, 10, 0, 0, int(123*time.Millisecond), time.UTC)
+	dateStart := now
+	dateEnd := now.Add(1 * time.Second)
+	formattedDateStart := "2019-09-01T10:00:00.123Z"
+	firstPage := pagination.Page{Limit: pagination.DefaultLimit}
 
 	repo := New(db)
-	msgID, err := repo.Create(MessageCreate{
+	ctx := context.Background()
+	msgID, err := repo.Create(ctx, MessageCreate{
 		UserID:  1,
-		TagID:   1,
+		TagIDs:  []int64{1},
 		Message: "Message 1",
 	})
 	require.Nil(t, err)
 	require.EqualValues(t, 1, msgID)
 
-	msgID, err = repo.Create(MessageCreate{
+	msgID, err = repo.Create(ctx, MessageCreate{
 		UserID:  1,
-		TagID:   2, // using tag 2 here to test the filter by tag later
+		TagIDs:  []int64{2}, // using tag 2 here to test the filter by tag later
 		Message: "Message 2",
 	})
 	require.Nil(t, err)
 	require.EqualValues(t, 2, msgID)
 
-	// sleeping 2 seconds to allow tests assertions by date range
-	time.Sleep(2 * time.Second)
-
-	msgID, err = repo.Create(MessageCreate{
+	msgID, err = repo.Create(ctx, MessageCreate{
 		UserID:  1,
-		TagID:   1,
+		TagIDs:  []int64{1},
 		Message: "Message 3",
 	})
 	require.Nil(t, err)
 	require.EqualValues(t, 3, msgID)
 
+	// the third message is created 2 seconds after the others to allow tests assertions by date range
+	_, err = db.Exec("UPDATE messages SET created_at = ? WHERE id IN (1, 2)", now.UnixMilli())
+	require.Nil(t, err)
+	_, err = db.Exec("UPDATE messages SET created_at = ? WHERE id = 3", now.Add(2*time.Second).UnixMilli())
+	require.Nil(t, err)
+
 	// testing CountMessages
-	count, err := repo.CountMessages(0, dateStart, dateEnd)
+	count, err := repo.CountMessages(ctx, Filter{DateStart: dateStart, DateEnd: dateEnd})
 	require.Nil(t, err)
 	require.EqualValues(t, 2, count)
 
-	count, err = repo.CountMessages(1, dateStart, dateEnd)
+	count, err = repo.CountMessages(ctx, Filter{TagID: 1, DateStart: dateStart, DateEnd: dateEnd})
 	require.Nil(t, err)
 	require.EqualValues(t, 1, count)
 
-	count, err = repo.CountMessages(2, dateStart, dateEnd)
+	count, err = repo.CountMessages(ctx, Filter{TagID: 2, DateStart: dateStart, DateEnd: dateEnd})
 	require.Nil(t, err)
 	require.EqualValues(t, 1, count)
 
-	count, err = repo.CountMessages(0, 0, 0)
+	count, err = repo.CountMessages(ctx, Filter{})
 	require.Nil(t, err)
 	require.EqualValues(t, 3, count)
 
+	// open-ended ranges, the start is included and the end is excluded
+	count, err = repo.CountMessages(ctx, Filter{DateStart: now.Add(2 * time.Second)})
+	require.Nil(t, err)
+	require.EqualValues(t, 1, count)
+
+	count, err = repo.CountMessages(ctx, Filter{DateEnd: now.Add(2 * time.Second)})
+	require.Nil(t, err)
+	require.EqualValues(t, 2, count)
+
 	// testing GetMessages
-	list, err := repo.GetMessages(0, dateStart, dateEnd)
+	list, err := repo.GetMessages(ctx, Filter{DateStart: dateStart, DateEnd: dateEnd}, firstPage)
 	require.Nil(t, err)
 	require.EqualValues(t, []MessageList{
 		{
-			ID:        1,
-			Message:   "Message 1",
-			CreatedAt: formattedDateStart,
-			UserEmail: "user@email.com",
-			Tag:       "tag-1",
+			ID:              1,
+			Message:         "Message 1",
+			CreatedAt:       formattedDateStart,
+			UserID:          1,
+			UserHandle:      "user",
+			UserDisplayName: "The User",
+			Tags:            []string{"tag-1"},
+			Entities:        []parser.Entity{},
+			ConversationID:  1,
 		},
 		{
-			ID:        2,
-			Message:   "Message 2",
-			CreatedAt: formattedDateStart,
-			UserEmail: "user@email.com",
-			Tag:       "tag-2",
+			ID:              2,
+			Message:         "Message 2",
+			CreatedAt:       formattedDateStart,
+			UserID:          1,
+			UserHandle:      "user",
+			UserDisplayName: "The User",
+			Tags:            []string{"tag-2"},
+			Entities:        []parser.Entity{},
+			ConversationID:  2,
 		},
-	}, list)
+	}, list.Messages)
 
-	list, err = repo.GetMessages(1, dateStart, dateEnd)
+	list, err = repo.GetMessages(ctx, Filter{TagID: 1, DateStart: dateStart, DateEnd: dateEnd}, firstPage)
 	require.Nil(t, err)
 	require.EqualValues(t, []MessageList{
 		{
-			ID:        1,
-			Message:   "Message 1",
-			CreatedAt: formattedDateStart,
-			UserEmail: "user@email.com",
-			Tag:       "tag-1",
+			ID:              1,
+			Message:         "Message 1",
+			CreatedAt:       formattedDateStart,
+			UserID:          1,
+			UserHandle:      "user",
+			UserDisplayName: "The User",
+			Tags:            []string{"tag-1"},
+			Entities:        []parser.Entity{},
+			ConversationID:  1,
 		},
-	}, list)
+	}, list.Messages)
 
-	list, err = repo.GetMessages(2, dateStart, dateEnd)
+	list, err = repo.GetMessages(ctx, Filter{TagID: 2, DateStart: dateStart, DateEnd: dateEnd}, firstPage)
 	require.Nil(t, err)
 	require.EqualValues(t, []MessageList{
 		{
-			ID:        2,
-			Message:   "Message 2",
-			CreatedAt: formattedDateStart,
-			UserEmail: "user@email.com",
-			Tag:       "tag-2",
+			ID:              2,
+			Message:         "Message 2",
+			CreatedAt:       formattedDateStart,
+			UserID:          1,
+			UserHandle:      "user",
+			UserDisplayName: "The User",
+			Tags:            []string{"tag-2"},
+			Entities:        []parser.Entity{},
+			ConversationID:  2,
 		},
-	}, list)
+	}, list.Messages)
 
-	list, err = repo.GetMessages(0, 0, 0)
+	list, err = repo.GetMessages(ctx, Filter{}, firstPage)
 	require.Nil(t, err)
 	require.EqualValues(t, []MessageList{
 		{
-			ID:        1,
-			Message:   "Message 1",
-			CreatedAt: formattedDateStart,
-			UserEmail: "user@email.com",
-			Tag:       "tag-1",
+			ID:              1,
+			Message:         "Message 1",
+			CreatedAt:       formattedDateStart,
+			UserID:          1,
+			UserHandle:      "user",
+			UserDisplayName: "The User",
+			Tags:            []string{"tag-1"},
+			Entities:        []parser.Entity{},
+			ConversationID:  1,
 		},
 		{
-			ID:        2,
-			Message:   "Message 2",
-			CreatedAt: formattedDateStart,
-			UserEmail: "user@email.com",
-			Tag:       "tag-2",
+			ID:              2,
+			Message:         "Message 2",
+			CreatedAt:       formattedDateStart,
+			UserID:          1,
+			UserHandle:      "user",
+			UserDisplayName: "The User",
+			Tags:            []string{"tag-2"},
+			Entities:        []parser.Entity{},
+			ConversationID:  2,
 		},
 		{
-			ID:        3,
-			Message:   "Message 3",
-			CreatedAt: now.Add(2 * time.Second).Format("2006-01-02T15:04:05"),
-			UserEmail: "user@email.com",
-			Tag:       "tag-1",
+			ID:              3,
+			Message:         "Message 3",
+			CreatedAt:       "2019-09-01T10:00:02.123Z",
+			UserID:          1,
+			UserHandle:      "user",
+			UserDisplayName: "The User",
+			Tags:            []string{"tag-1"},
+			Entities:        []parser.Entity{},
+			ConversationID:  3,
 		},
-	}, list)
+	}, list.Messages)
+
+	// testing Get
+	msg, err := repo.Get(ctx, 2, 0)
+	require.Nil(t, err)
+	require.Equal(t, &MessageList{
+		ID:              2,
+		Message:         "Message 2",
+		CreatedAt:       formattedDateStart,
+		UserID:          1,
+		UserHandle:      "user",
+		UserDisplayName: "The User",
+		Tags:            []string{"tag-2"},
+		Entities:        []parser.Entity{},
+		ConversationID:  2,
+	}, msg)
+
+	_, err = repo.Get(ctx, 4, 0)
+	require.Equal(t, sql.ErrNoRows, err)
+
+	// testing pagination
+	page := pagination.Page{Limit: 2}
+	list, err = repo.GetMessages(ctx, Filter{}, page)
+	require.Nil(t, err)
+	require.Len(t, list.Messages, 2)
+	require.EqualValues(t, 1, list.Messages[0].ID)
+	require.EqualValues(t, 2, list.Messages[1].ID)
+	require.Nil(t, list.Prev)
+	require.Equal(t, &pagination.Cursor{CreatedAt: dateStart.UnixMilli(), ID: 2}, list.Next)
+
+	page.Cursor = list.Next
+	list, err = repo.GetMessages(ctx, Filter{}, page)
+	require.Nil(t, err)
+	require.Len(t, list.Messages, 1)
+	require.EqualValues(t, 3, list.Messages[0].ID)
+	require.Nil(t, list.Next)
+	require.Equal(t, &pagination.Cursor{CreatedAt: dateStart.Add(2 * time.Second).UnixMilli(), ID: 3, Backward: true}, list.Prev)
+
+	page.Cursor = list.Prev
+	list, err = repo.GetMessages(ctx, Filter{}, page)
+	require.Nil(t, err)
+	require.Len(t, list.Messages, 2)
+	require.EqualValues(t, 1, list.Messages[0].ID)
+	require.EqualValues(t, 2, list.Messages[1].ID)
+	require.Nil(t, list.Prev)
+	require.Equal(t, &pagination.Cursor{CreatedAt: dateStart.UnixMilli(), ID: 2}, list.Next)
+
+	// testing messages with many tags are neither duplicated nor counted twice
+	msgID, err = repo.Create(ctx, MessageCreate{
+		UserID:   1,
+		TagIDs:   []int64{2, 1},
+		Mentions: []string{"alice", "bob"},
+		Message:  "Message 4 for @alice and @bob",
+	})
+	require.Nil(t, err)
+
+	var mentions int
+	err = db.QueryRow("SELECT COUNT(*) FROM mentions WHERE message_id = ?", msgID).Scan(&mentions)
+	require.Nil(t, err)
+	require.Equal(t, 2, mentions)
+
+	count, err = repo.CountMessages(ctx, Filter{})
+	require.Nil(t, err)
+	require.EqualValues(t, 4, count)
+
+	count, err = repo.CountMessages(ctx, Filter{TagID: 1})
+	require.Nil(t, err)
+	require.EqualValues(t, 3, count)
+
+	list, err = repo.GetMessages(ctx, Filter{TagID: 2}, firstPage)
+	require.Nil(t, err)
+	require.Len(t, list.Messages, 2)
+	require.Equal(t, []string{"tag-1", "tag-2"}, list.Messages[1].Tags)
+
+	msg, err = repo.Get(ctx, msgID, 0)
+	require.Nil(t, err)
+	require.Equal(t, []string{"tag-1", "tag-2"}, msg.Tags)
+	require.Equal(t, []parser.Entity{
+		{Type: parser.Mention, Text: "alice", Start: 14, End: 20},
+		{Type: parser.Mention, Text: "bob", Start: 25, End: 29},
+	}, msg.Entities)
+}
+
+func TestMessagesRepository_CreateError(t *testing.T) {
+	const dbDsn = "./testdata/test10.db"
+	db := testutils.SetUp(t, dbDsn)
+	defer testutils.TearDown(t, db, []string{dbDsn})
+
+	loadFixtures(t, db)
+	_, err := db.Exec(`CREATE TRIGGER messages_full BEFORE INSERT ON messages BEGIN
+		SELECT RAISE(ABORT, 'database or disk is full');
+	END`)
+	require.Nil(t, err)
+
+	// the errors are logged, they must not leak the text of the messages
+	_, err = New(db).Create(context.Background(), MessageCreate{UserID: 1, Message: "A private thought"})
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "database or disk is full")
+	require.NotContains(t, err.Error(), "A private thought")
+}
+
+func TestMessagesRepository_Timeline(t *testing.T) {
+	const dbDsn = "./testdata/test3.db"
+	db := testutils.SetUp(t, dbDsn)
+	defer testutils.TearDown(t, db, []string{dbDsn})
+
+	loadFixtures(t, db)
+
+	_, err := db.Exec("INSERT INTO users (id, email, handle) VALUES (2, 'b@email.com', 'b'), (3, 'c@email.com', 'c')")
+	require.Nil(t, err)
+	_, err = db.Exec("INSERT INTO follows (follower_id, followee_id, created_at) VALUES (1, 2, 0)")
+	require.Nil(t, err)
+
+	repo := New(db)
+	ctx := context.Background()
+	for userID := int64(1); userID <= 3; userID++ {
+		_, err := repo.Create(ctx, MessageCreate{UserID: userID, TagIDs: []int64{1}, Message: "Hello"})
+		require.Nil(t, err)
+	}
+
+	list, err := repo.GetMessages(ctx, Filter{FollowedBy: 1}, pagination.Page{Limit: 10})
+	require.Nil(t, err)
+	require.Len(t, list.Messages, 1)
+	require.Equal(t, "b", list.Messages[0].UserHandle)
+
+	count, err := repo.CountMessages(ctx, Filter{FollowedBy: 1, TagID: 2})
+	require.Nil(t, err)
+	require.EqualValues(t, 0, count)
+}
+
+func TestMessagesRepository_Thread(t *testing.T) {
+	const dbDsn = "./testdata/test4.db"
+	db := testutils.SetUp(t, dbDsn)
+	defer testutils.TearDown(t, db, []string{dbDsn})
+
+	loadFixtures(t, db)
+
+	repo := New(db)
+	ctx := context.Background()
+	create := func(inReplyTo int64) int64 {
+		msgID, err := repo.Create(ctx, MessageCreate{UserID: 1, Message: "Hello", InReplyTo: inReplyTo})
+		require.Nil(t, err)
+		return msgID
+	}
+
+	root := create(0)        // 1
+	first := create(root)    // 2
+	second := create(root)   // 3
+	nested := create(first)  // 4
+	other := create(0)       // 5, another conversation
+	deeper := create(nested) // 6
+
+	_, err := repo.Create(ctx, MessageCreate{UserID: 1, Message: "Hello", InReplyTo: 42})
+	require.Equal(t, ErrParentNotFound, err)
+
+	thread, err := repo.GetThread(ctx, nested, 0) // any message of the conversation returns the whole thread
+	require.Nil(t, err)
+
+	var ids []int64
+	var depths []int
+	for _, msg := range thread {
+		ids = append(ids, msg.ID)
+		depths = append(depths, msg.Depth)
+		require.Equal(t, root, msg.ConversationID)
+	}
+	require.Equal(t, []int64{root, first, nested, deeper, second}, ids)
+	require.Equal(t, []int{0, 1, 2, 3, 1}, depths)
+	require.Nil(t, thread[0].InReplyTo)
+	require.Equal(t, root, *thread[1].InReplyTo)
+	require.EqualValues(t, 2, thread[0].ReplyCount)
+	require.EqualValues(t, 1, thread[1].ReplyCount)
+
+	thread, err = repo.GetThread(ctx, other, 0)
+	require.Nil(t, err)
+	require.Len(t, thread, 1)
+
+	_, err = repo.GetThread(ctx, 42, 0)
+	require.Equal(t, sql.ErrNoRows, err)
+}
+
+func TestMessagesRepository_Reposts(t *testing.T) {
+	const dbDsn = "./testdata/test5.db"
+	db := testutils.SetUp(t, dbDsn)
+	defer testutils.TearDown(t, db, []string{dbDsn})
+
+	loadFixtures(t, db)
+	_, err := db.Exec("INSERT INTO users (id, email, handle) VALUES (?, ?, ?)", 2, "reposter@email.com", "reposter")
+	require.Nil(t, err)
+
+	repo := New(db)
+	ctx := context.Background()
+	original, err := repo.Create(ctx, MessageCreate{UserID: 1, Message: "Worth sharing"})
+	require.Nil(t, err)
+
+	repost, err := repo.Repost(ctx, 2, original)
+	require.Nil(t, err)
+
+	again, err := repo.Repost(ctx, 2, original)
+	require.Nil(t, err)
+	require.Equal(t, repost, again, "reposting twice must return the existing repost")
+
+	again, err = repo.Repost(ctx, 2, repost) // reposting a repost reposts the original
+	require.Nil(t, err)
+	require.Equal(t, repost, again)
+
+	_, err = repo.Repost(ctx, 2, 42)
+	require.Equal(t, sql.ErrNoRows, err)
+
+	quote, err := repo.Create(ctx, MessageCreate{UserID: 2, Message: "So true", QuoteOf: repost})
+	require.Nil(t, err)
+
+	_, err = repo.Create(ctx, MessageCreate{UserID: 2, Message: "So true", QuoteOf: 42})
+	require.Equal(t, ErrQuotedNotFound, err)
+
+	msg, err := repo.Get(ctx, original, 0)
+	require.Nil(t, err)
+	require.EqualValues(t, 1, msg.RepostCount)
+	require.EqualValues(t, 1, msg.QuoteCount)
+	require.Nil(t, msg.RepostOf)
+
+	msg, err = repo.Get(ctx, repost, 0)
+	require.Nil(t, err)
+	require.Equal(t, "reposter", msg.UserHandle)
+	require.Equal(t, repost, msg.ConversationID)
+	require.NotNil(t, msg.RepostOf)
+	require.Equal(t, original, msg.RepostOf.ID)
+	require.Equal(t, "user", msg.RepostOf.UserHandle)
+	require.Equal(t, "Worth sharing", msg.RepostOf.Message)
+
+	page, err := repo.GetMessages(ctx, Filter{}, pagination.Page{Limit: 10})
+	require.Nil(t, err)
+	require.Len(t, page.Messages, 3)
+	require.Equal(t, quote, page.Messages[2].ID)
+	require.NotNil(t, page.Messages[2].QuoteOf)
+	require.Equal(t, original, page.Messages[2].QuoteOf.ID) // quoting a repost quotes the original
+	require.Nil(t, page.Messages[2].QuoteOf.RepostOf)
+
+	// the reposts are listed but not counted
+	count, err := repo.CountMessages(ctx, Filter{})
+	require.Nil(t, err)
+	require.EqualValues(t, 2, count)
+
+	require.Nil(t, repo.Unrepost(ctx, 2, original))
+	require.Nil(t, repo.Unrepost(ctx, 2, original)) // undoing twice is not an error
+
+	_, err = repo.Get(ctx, repost, 0)
+	require.Equal(t, sql.ErrNoRows, err)
+
+	msg, err = repo.Get(ctx, original, 0)
+	require.Nil(t, err)
+	require.EqualValues(t, 0, msg.RepostCount)
+
+	// the repost is soft deleted like the deleted messages, reposting again restores it
+	var deleted bool
+	require.Nil(t, db.QueryRow("SELECT deleted_at IS NOT NULL FROM messages WHERE id = ?", repost).Scan(&deleted))
+	require.True(t, deleted)
+
+	again, err = repo.Repost(ctx, 2, original)
+	require.Nil(t, err)
+	require.Equal(t, repost, again)
+
+	msg, err = repo.Get(ctx, original, 0)
+	require.Nil(t, err)
+	require.EqualValues(t, 1, msg.RepostCount)
+}
+
+func TestMessagesRepository_Likes(t *testing.T) {
+	const dbDsn = "./testdata/test6.db"
+	db := testutils.SetUp(t, dbDsn)
+	defer testutils.TearDown(t, db, []string{dbDsn})
+
+	loadFixtures(t, db)
+	_, err := db.Exec("INSERT INTO users (id, email) VALUES (?, ?)", 2, "fan@email.com")
+	require.Nil(t, err)
+
+	repo := New(db)
+	ctx := context.Background()
+	liked, err := repo.Create(ctx, MessageCreate{UserID: 1, Message: "Like me"})
+	require.Nil(t, err)
+	_, err = repo.Create(ctx, MessageCreate{UserID: 1, Message: "Nobody likes me"})
+	require.Nil(t, err)
+	repost, err := repo.Repost(ctx, 2, liked)
+	require.Nil(t, err)
+
+	require.Nil(t, repo.Like(ctx, 2, liked))
+	require.Nil(t, repo.Like(ctx, 2, liked))  // liking twice is not an error
+	require.Nil(t, repo.Like(ctx, 2, repost)) // liking a repost likes the original
+	require.Equal(t, sql.ErrNoRows, repo.Like(ctx, 2, 42))
+
+	msg, err := repo.Get(ctx, liked, 2)
+	require.Nil(t, err)
+	require.EqualValues(t, 1, msg.LikeCount)
+	require.True(t, msg.Liked)
+
+	msg, err = repo.Get(ctx, liked, 1) // the flag is relative to the viewer
+	require.Nil(t, err)
+	require.False(t, msg.Liked)
+
+	msg, err = repo.Get(ctx, repost, 2)
+	require.Nil(t, err)
+	require.False(t, msg.Liked)
+	require.True(t, msg.RepostOf.Liked)
+
+	page, err := repo.GetMessages(ctx, Filter{LikedBy: 2, ViewerID: 2}, pagination.Page{Limit: 10})
+	require.Nil(t, err)
+	require.Len(t, page.Messages, 1)
+	require.Equal(t, liked, page.Messages[0].ID)
+	require.True(t, page.Messages[0].Liked)
+
+	require.Nil(t, repo.Unlike(ctx, 2, liked))
+	require.Nil(t, repo.Unlike(ctx, 2, liked)) // unliking twice is not an error
+
+	msg, err = repo.Get(ctx, liked, 2)
+	require.Nil(t, err)
+	require.EqualValues(t, 0, msg.LikeCount)
+	require.False(t, msg.Liked)
+}
+
+func TestMessagesRepository_UpdateAndDelete(t *testing.T) {
+	const dbDsn = "./testdata/test7.db"
+	db := testutils.SetUp(t, dbDsn)
+	defer testutils.TearDown(t, db, []string{dbDsn})
+
+	loadFixtures(t, db)
+	_, err := db.Exec("INSERT INTO users (id, email) VALUES (?, ?)", 2, "other@email.com")
+	require.Nil(t, err)
+
+	repo := New(db)
+	ctx := context.Background()
+	root, err := repo.Create(ctx, MessageCreate{UserID: 1, TagIDs: []int64{1}, Message: "Helo"})
+	require.Nil(t, err)
+	reply, err := repo.Create(ctx, MessageCreate{UserID: 2, Message: "Hi", InReplyTo: root})
+	require.Nil(t, err)
+	repost, err := repo.Repost(ctx, 2, root)
+	require.Nil(t, err)
+
+	err = repo.Update(ctx, MessageUpdate{ID: root, UserID: 2, Message: "Hijacked"})
+	require.Equal(t, ErrNotAuthor, err)
+	err = repo.Update(ctx, MessageUpdate{ID: repost, UserID: 2, Message: "Not a repost anymore"})
+	require.Equal(t, ErrNotEditable, err)
+	err = repo.Update(ctx, MessageUpdate{ID: 42, UserID: 1, Message: "Missing"})
+	require.Equal(t, sql.ErrNoRows, err)
+
+	// CheckEditable returns the same errors without updating anything
+	require.Equal(t, ErrNotAuthor, repo.CheckEditable(ctx, 2, root))
+	require.Equal(t, ErrNotEditable, repo.CheckEditable(ctx, 2, repost))
+	require.Equal(t, sql.ErrNoRows, repo.CheckEditable(ctx, 1, 42))
+	require.Nil(t, repo.CheckEditable(ctx, 1, root))
+
+	require.Nil(t, repo.Update(ctx, MessageUpdate{ID: root, UserID: 1, TagIDs: []int64{2}, Message: "Hello"}))
+
+	msg, err := repo.Get(ctx, root, 0)
+	require.Nil(t, err)
+	require.Equal(t, "Hello", msg.Message)
+	require.Equal(t, []string{"tag-2"}, msg.Tags)
+	require.NotNil(t, msg.EditedAt)
+
+	history, err := repo.GetHistory(ctx, root)
+	require.Nil(t, err)
+	require.Len(t, history, 2)
+	require.Equal(t, "Helo", history[0].Message)
+	require.Equal(t, "Hello", history[1].Message)
+
+	// messages can't be edited once the edit window is over
+	createdAt := time.Now().Add(-EditWindow - time.Minute).UnixMilli()
+	_, err = db.Exec("UPDATE messages SET created_at = ? WHERE id = ?", createdAt, reply)
+	require.Nil(t, err)
+	err = repo.Update(ctx, MessageUpdate{ID: reply, UserID: 2, Message: "Hi!"})
+	require.Equal(t, ErrNotEditable, err)
+
+	require.Equal(t, ErrNotAuthor, repo.Delete(ctx, 2, root))
+	require.Nil(t, repo.Delete(ctx, 1, root))
+	require.Equal(t, sql.ErrNoRows, repo.Delete(ctx, 1, root))
+
+	_, err = repo.Get(ctx, root, 0)
+	require.Equal(t, sql.ErrNoRows, err)
+	_, err = repo.Get(ctx, repost, 0) // reposts are deleted along with the original message
+	require.Equal(t, sql.ErrNoRows, err)
+	_, err = repo.GetHistory(ctx, root)
+	require.Equal(t, sql.ErrNoRows, err)
+
+	count, err := repo.CountMessages(ctx, Filter{})
+	require.Nil(t, err)
+	require.EqualValues(t, 1, count)
+	count, err = repo.CountMessages(ctx, Filter{TagID: 2})
+	require.Nil(t, err)
+	require.EqualValues(t, 0, count)
+
+	page, err := repo.GetMessages(ctx, Filter{}, pagination.Page{Limit: 10})
+	require.Nil(t, err)
+	require.Len(t, page.Messages, 1)
+	require.Equal(t, reply, page.Messages[0].ID)
+
+	// the thread keeps the deleted message as a placeholder
+	thread, err := repo.GetThread(ctx, reply, 0)
+	require.Nil(t, err)
+	require.Len(t, thread, 2)
+	require.True(t, thread[0].Deleted)
+	require.Equal(t, "", thread[0].Message)
+	require.Equal(t, []string{}, thread[0].Tags)
+	require.Equal(t, reply, thread[1].ID)
+
+	_, err = repo.Create(ctx, MessageCreate{UserID: 2, Message: "Hi again", InReplyTo: root})
+	require.Equal(t, ErrParentNotFound, err)
+	_, err = repo.Repost(ctx, 2, root)
+	require.Equal(t, sql.ErrNoRows, err)
+	require.Equal(t, sql.ErrNoRows, repo.Like(ctx, 2, root))
 }
 
 func loadFixtures(t *testing.T, db *sql.DB) {
-	_, err := db.Exec("INSERT INTO users (id, email) VALUES (?, ?)", 1, "user@email.com")
+	_, err := db.Exec(
+		"INSERT INTO users (id, email, handle, display_name) VALUES (?, ?, ?, ?)", 1, "user@email.com", "user", "The User",
+	)
 	require.Nil(t, err)
 
 	_, err = db.Exec("INSERT INTO main.tags (id, tag) VALUES (?, ?), (?, ?)", 1, "tag-1", 2, "tag-2")
 	require.Nil(t, err)
 }
+
+func TestMessagesRepository_GetStats(t *testing.T) {
+	const dbDsn = "./testdata/test9.db"
+	db := testutils.SetUp(t, dbDsn)
+	defer testutils.TearDown(t, db, []string{dbDsn})
+
+	loadFixtures(t, db)
+	_, err := db.Exec("INSERT INTO users (id, email) VALUES (?, ?)", 2, "other@email.com")
+	require.Nil(t, err)
+
+	// daylight saving time ends on 2019-10-27 in Rome, that day is 25 hours long
+	rome, err := time.LoadLocation("Europe/Rome")
+	require.Nil(t, err)
+	for i, msg := range []struct {
+		userID    int64
+		createdAt time.Time
+		tagIDs    []int64
+	}{
+		{1, time.Date(2019, 10, 25, 23, 30, 0, 0, rome), []int64{1}}, // before the range
+		{1, time.Date(2019, 10, 26, 10, 0, 0, 0, rome), []int64{1}},
+		{2, time.Date(2019, 10, 27, 23, 30, 0, 0, rome), []int64{1, 2}},
+		{1, time.Date(2019, 10, 28, 0, 30, 0, 0, rome), nil}, // after the range
+	} {
+		_, err := db.Exec(
+			"INSERT INTO messages (id, user_id, message, created_at, conversation_id) VALUES (?, ?, 'message', ?, ?)",
+			i+1, msg.userID, msg.createdAt.UnixMilli(), i+1,
+		)
+		require.Nil(t, err)
+		for _, tagID := range msg.tagIDs {
+			_, err := db.Exec("INSERT INTO message_tag (message_id, tag_id) VALUES (?, ?)", i+1, tagID)
+			require.Nil(t, err)
+		}
+	}
+
+	repo := New(db)
+	ctx := context.Background()
+	filter := Filter{
+		DateStart: time.Date(2019, 10, 26, 0, 0, 0, 0, rome),
+		DateEnd:   time.Date(2019, 10, 28, 0, 0, 0, 0, rome),
+	}
+	points := func(counts ...int64) []Point {
+		starts := []string{"2019-10-26T00:00:00.000+02:00", "2019-10-27T00:00:00.000+02:00"}
+		result := make([]Point, len(counts))
+		for i, count := range counts {
+			result[i] = Point{Start: starts[i], Count: count}
+		}
+		return result
+	}
+
+	series, err := repo.GetStats(ctx, StatsQuery{Filter: filter, Interval: Day, Location: rome})
+	require.Nil(t, err)
+	require.Equal(t, []Series{{Points: points(1, 1)}}, series)
+
+	series, err = repo.GetStats(ctx, StatsQuery{Filter: filter, Interval: Day, Location: rome, GroupBy: GroupByTag})
+	require.Nil(t, err)
+	require.Equal(t, []Series{{Key: "tag-1", Points: points(1, 1)}, {Key: "tag-2", Points: points(0, 1)}}, series)
+
+	series, err = repo.GetStats(ctx, StatsQuery{Filter: filter, Interval: Day, Location: rome, GroupBy: GroupByUser})
+	require.Nil(t, err)
+	require.Equal(t, []Series{{Key: "1", Points: points(1, 0)}, {Key: "2", Points: points(0, 1)}}, series)
+
+	// only the keys with the most messages get a series of their own
+	query := StatsQuery{Filter: filter, Interval: Day, Location: rome, GroupBy: GroupByTag, Limit: 1}
+	series, err = repo.GetStats(ctx, query)
+	require.Nil(t, err)
+	require.Equal(t, []Series{{Key: "tag-1", Points: points(1, 1)}, {Other: true, Points: points(0, 1)}}, series)
+
+	query.Limit = 2
+	series, err = repo.GetStats(ctx, query)
+	require.Nil(t, err)
+	require.Equal(t, []Series{{Key: "tag-1", Points: points(1, 1)}, {Key: "tag-2", Points: points(0, 1)}}, series)
+
+	// the filters apply, an empty series is still returned when the messages are not grouped
+	tagFilter := filter
+	tagFilter.TagID = 2
+	series, err = repo.GetStats(ctx, StatsQuery{Filter: tagFilter, Interval: Day, Location: rome})
+	require.Nil(t, err)
+	require.Equal(t, []Series{{Points: points(0, 1)}}, series)
+
+	tagFilter.DateEnd = time.Date(2019, 10, 27, 0, 0, 0, 0, rome)
+	series, err = repo.GetStats(ctx, StatsQuery{Filter: tagFilter, Interval: Day, Location: rome})
+	require.Nil(t, err)
+	require.Equal(t, []Series{{Points: points(0)}}, series)
+
+	series, err = repo.GetStats(ctx, StatsQuery{Filter: tagFilter, Interval: Day, Location: rome, GroupBy: GroupByTag})
+	require.Nil(t, err)
+	require.Equal(t, []Series{}, series)
+
+	// the weeks start on Monday
+	series, err = repo.GetStats(ctx, StatsQuery{Filter: filter, Interval: Week, Location: rome})
+	require.Nil(t, err)
+	require.Equal(t, []Series{{Points: []Point{{Start: "2019-10-21T00:00:00.000+02:00", Count: 2}}}}, series)
+
+	series, err = repo.GetStats(ctx, StatsQuery{Filter: filter, Interval: Hour, Location: time.UTC})
+	require.Nil(t, err)
+	require.Len(t, series[0].Points, 49)
+	require.Equal(t, Point{Start: "2019-10-25T22:00:00.000Z", Count: 0}, series[0].Points[0])
+	require.Equal(t, Point{Start: "2019-10-26T08:00:00.000Z", Count: 1}, series[0].Points[10])
+
+	filter.DateStart = time.Date(2019, 1, 1, 0, 0, 0, 0, rome)
+	_, err = repo.GetStats(ctx, StatsQuery{Filter: filter, Interval: Hour, Location: rome})
+	require.Equal(t, ErrTooManyBuckets, err)
+}
+
+func TestMessagesRepository_Search(t *testing.T) {
+	const dbDsn = "./testdata/test2.db"
+	db := testutils.SetUp(t, dbDsn)
+	defer testutils.TearDown(t, db, []string{dbDsn})
+
+	if fts5, err := sqlite.HasFTS5(context.Background(), db); err != nil || !fts5 {
+		t.Skip("SQLite compiled without FTS5, run the tests with -tags sqlite_fts5")
+	}
+
+	loadFixtures(t, db)
+
+	repo := New(db)
+	ctx := context.Background()
+	for i, text := range []string{
+		"Gophers love SQLite",
+		"SQLite, SQLite and more SQLite",
+		"Nothing to see here",
+	} {
+		_, err := repo.Create(ctx, MessageCreate{UserID: 1, TagIDs: []int64{int64(i%2 + 1)}, Message: text})
+		require.Nil(t, err)
+	}
+
+	results, err := repo.Search(ctx, "sqlite", Filter{}, 10)
+	require.Nil(t, err)
+	require.Len(t, results, 2)
+	require.EqualValues(t, 2, results[0].ID) // more occurrences, ranked first
+	require.EqualValues(t, 1, results[1].ID)
+	require.Equal(t, "Gophers love <mark>SQLite</mark>", results[1].Snippet)
+
+	results, err = repo.Search(ctx, "sqlite", Filter{TagID: 1}, 10)
+	require.Nil(t, err)
+	require.Len(t, results, 1)
+	require.EqualValues(t, 1, results[0].ID)
+
+	results, err = repo.Search(ctx, `gophers" OR "nothing`, Filter{}, 10) // FTS5 syntax is not interpreted
+	require.Nil(t, err)
+	require.Len(t, results, 0)
+}
+
+func TestMessagesRepository_Cancellation(t *testing.T) {
+	const dbDsn = "./testdata/test8.db"
+	db := testutils.SetUp(t, dbDsn)
+	defer testutils.TearDown(t, db, []string{dbDsn})
+
+	loadFixtures(t, db)
+	// enough messages for counting them to take way longer than the deadline below
+	_, err := db.Exec(`WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 200000)
+		INSERT INTO messages (user_id, message, created_at, conversation_id) SELECT 1, 'message', i, i FROM n`)
+	require.Nil(t, err)
+
+	repo := New(db)
+
+	// the db has a single connection (see sqlite.New): if a cancelled call didn't release it, the next call
+	// would wait for it until its own deadline
+	requireReleased := func() {
+		require.Equal(t, 0, db.Stats().InUse)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := repo.Get(ctx, 1, 0)
+		require.Nil(t, err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = repo.Get(cancelled, 1, 0)
+	require.NotNil(t, err)
+	requireReleased()
+
+	_, err = repo.Create(cancelled, MessageCreate{UserID: 1, Message: "Never written"})
+	require.NotNil(t, err)
+	requireReleased()
+
+	// the query is interrupted while running
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = repo.CountMessages(ctx, Filter{})
+	require.NotNil(t, err)
+	require.True(t, time.Since(start) < time.Second, "the query has not been interrupted")
+	requireReleased()
+
+	count, err := repo.CountMessages(context.Background(), Filter{})
+	require.Nil(t, err)
+	require.EqualValues(t, 200000, count)
+}
@@ -1,18 +1,153 @@
 package messages
 
+import (
+	"go-twitter-test/pagination"
+	"go-twitter-test/parser"
+	"time"
+)
+
 // MessageCreate is a model used when creating a new message (see POST /v1/messages)
 type MessageCreate struct {
-	ID      int64
-	UserID  int64
-	TagID   int64
-	Message string
+	ID       int64
+	UserID   int64
+	TagIDs   []int64
+	Mentions []string // handles of the mentioned users
+	Message  string
+	// InReplyTo is the ID of the message being replied to, 0 if the message starts a new conversation
+	InReplyTo int64
+	// QuoteOf is the ID of the message being quoted, 0 if the message is not a quote
+	QuoteOf int64
+}
+
+// MessageUpdate is a model used when editing a message (see PATCH /v1/messages/{id}), the tags and the mentions
+// replace the ones of the message
+type MessageUpdate struct {
+	ID       int64
+	UserID   int64
+	TagIDs   []int64
+	Mentions []string
+	Message  string
 }
 
-// MessageList is used when returning a list of messages (see GET /v1/messages)
+// MessageList is used when returning a list of messages or a single one (see GET /v1/messages).
+// The timestamps are formatted with TimeLayout.
 type MessageList struct {
 	ID        int64  `json:"id"`
 	Message   string `json:"message"`
 	CreatedAt string `json:"created_at"`
-	UserEmail string `json:"user_email"`
-	Tag       string `json:"tag"`
+	// UserHandle and UserDisplayName are the ones of the author, the handle is empty for the users created before
+	// handles were introduced
+	UserID          int64    `json:"user_id"`
+	UserHandle      string   `json:"user_handle"`
+	UserDisplayName string   `json:"user_display_name"`
+	Tags            []string `json:"tags"`
+	// Entities are the hashtags and mentions found in the message text along with their offsets
+	Entities []parser.Entity `json:"entities"`
+	// InReplyTo is nil when the message is the root of a conversation
+	InReplyTo      *int64 `json:"in_reply_to"`
+	ConversationID int64  `json:"conversation_id"`
+	ReplyCount     int64  `json:"reply_count"`
+	// RepostOf is the original message when this message is a repost, the author being the reposter.
+	// QuoteOf is the quoted message. Embedded messages don't embed their own reposted or quoted messages.
+	RepostOf    *MessageList `json:"repost_of,omitempty"`
+	QuoteOf     *MessageList `json:"quote_of,omitempty"`
+	RepostCount int64        `json:"repost_count"`
+	QuoteCount  int64        `json:"quote_count"`
+	LikeCount   int64        `json:"like_count"`
+	// Liked tells whether the user viewing the message (see Filter.ViewerID) likes it
+	Liked bool `json:"liked"`
+	// EditedAt is nil if the message has never been edited
+	EditedAt *string `json:"edited_at"`
+	// Deleted messages are only returned in threads, without text, tags and entities
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// Revision is a version of the text of a message along with when it was written
+type Revision struct {
+	Message   string `json:"message"`
+	CreatedAt string `json:"created_at"`
+}
+
+// ThreadMessage is a message of a conversation, Depth is 0 for the root message, 1 for its replies and so on
+type ThreadMessage struct {
+	MessageList
+	Depth int `json:"depth"`
+}
+
+// MessagePage is a page of messages along with the cursors pointing to the pages next to it (nil if there's none)
+type MessagePage struct {
+	Messages []MessageList
+	Next     *pagination.Cursor
+	Prev     *pagination.Cursor
+}
+
+// Filter narrows down the messages to be listed or counted, zero values mean no filter.
+// The messages created from DateStart (included) to DateEnd (excluded) are kept, either can be used alone.
+type Filter struct {
+	TagID     int64
+	DateStart time.Time
+	DateEnd   time.Time
+	// FollowedBy keeps only the messages written by the users followed by this user (i.e. their timeline)
+	FollowedBy int64
+	// LikedBy keeps only the messages liked by this user
+	LikedBy int64
+	// ViewerID is the user viewing the messages, it doesn't narrow them down but sets their Liked flag
+	ViewerID int64
+}
+
+// SearchResult is a message matching a full-text search
+type SearchResult struct {
+	MessageList
+	// Snippet is an excerpt of the message with the matching terms wrapped in <mark></mark>
+	Snippet string `json:"snippet"`
+	// Rank is the bm25 score of the message, the lower the more relevant
+	Rank float64 `json:"rank"`
+}
+
+// Interval is the size of the buckets of the message stats
+type Interval string
+
+const (
+	Hour Interval = "hour"
+	Day  Interval = "day"
+	// Week buckets start on Mondays (ISO 8601)
+	Week Interval = "week"
+)
+
+// GroupBy splits the message stats in one series per tag or per user
+type GroupBy string
+
+const (
+	GroupByTag  GroupBy = "tag"
+	GroupByUser GroupBy = "user"
+)
+
+// StatsQuery describes the time series returned by GetStats (see GET /v1/stats/messages).
+// The filter must have both DateStart and DateEnd, the first bucket is the one DateStart falls in.
+type StatsQuery struct {
+	Filter   Filter
+	Interval Interval
+	// Location is the time zone the buckets are aligned to, e.g. the days start at its midnight
+	Location *time.Location
+	// GroupBy is empty when the messages are counted in a single series
+	GroupBy GroupBy
+	// Limit is the maximum number of keys getting a series of their own when grouped (0 for no limit), the ones
+	// with the most messages: the messages of the other keys are counted in a last Other series
+	Limit int
+}
+
+// Series is the number of messages created in every bucket, Key is the tag or the ID of the user when the
+// messages are grouped. Messages with many tags are counted in the series of each of their tags.
+// The Other series has no Key, it counts the messages of the keys left out by StatsQuery.Limit.
+type Series struct {
+	Key    string  `json:"key,omitempty"`
+	Other  bool    `json:"other,omitempty"`
+	Points []Point `json:"points"`
+}
+
+// Point is the number of messages created in the bucket starting at Start (formatted with TimeLayout in the
+// time zone of the query), the empty buckets are returned too
+type Point struct {
+	Start string `json:"start"`
+	Count int64  `json:"count"`
 }
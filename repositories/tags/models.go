@@ -0,0 +1,45 @@
+package tags
+
+import "go-twitter-test/pagination"
+
+// TrendingTag is a tag used more than usual recently, see Repository.RefreshTrending for how it's scored
+type TrendingTag struct {
+	Tag   string  `json:"tag"`
+	Score float64 `json:"score"`
+	// Uses is the number of messages tagged with it within the window
+	Uses int64 `json:"uses"`
+}
+
+// Sort is the order in which List returns the tags
+type Sort string
+
+const (
+	// SortByName sorts the tags alphabetically
+	SortByName Sort = "name"
+	// SortByUsage sorts the most used tags first, the ones used as much alphabetically
+	SortByUsage Sort = "usage"
+)
+
+// Tag is a tag along with the number of messages (not deleted) tagged with it
+type Tag struct {
+	Tag  string `json:"tag"`
+	Uses int64  `json:"uses"`
+}
+
+// TagPage is a page of tags along with the cursors pointing to the pages next to it (nil if there's none)
+type TagPage struct {
+	Tags []Tag
+	Next *pagination.Cursor
+	Prev *pagination.Cursor
+}
+
+// TagDetail describes how a tag is used, the times are empty when no message is tagged with it
+type TagDetail struct {
+	Tag
+	// FirstSeen is the creation time of the first message tagged with it
+	FirstSeen string `json:"first_seen,omitempty"`
+	// LastUsed is the creation time of the last message tagged with it
+	LastUsed string `json:"last_used,omitempty"`
+	// Related are the tags used the most along with it, Uses being the number of messages tagged with both
+	Related []Tag `json:"related"`
+}
@@ -1,42 +1,66 @@
 package tags
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"go-twitter-test/pagination"
+	"go-twitter-test/repositories/messages"
+	"go-twitter-test/sqlite"
+	"go-twitter-test/tracing"
+	"math"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 //go:generate counterfeiter . Repository
 type Repository interface {
-	Put(tag string) (int64, error)
-	GetID(tag string) (int64, error)
+	Put(ctx context.Context, tag string) (int64, error)
+	PutMany(ctx context.Context, tags []string) ([]int64, error)
+	GetID(ctx context.Context, tag string) (int64, error)
+	RefreshTrending(ctx context.Context, now time.Time, window, baseline time.Duration) error
+	GetTrending(ctx context.Context, limit int) ([]TrendingTag, error)
+	List(ctx context.Context, sort Sort, page pagination.Page) (*TagPage, error)
+	Autocomplete(ctx context.Context, prefix string, limit int) ([]Tag, error)
+	GetDetail(ctx context.Context, tag string, related int) (*TagDetail, error)
 }
 
 type tagsRepository struct {
 	db *sql.DB
 }
 
-func (r *tagsRepository) GetID(tag string) (int64, error) {
-	tag = r.tokenize(tag)
+func (r *tagsRepository) GetID(ctx context.Context, tag string) (tagID int64, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "tags", "GetID")
+	defer func() { tracing.End(span, err) }()
 
-	var tagID int64
-	err := r.db.QueryRow("SELECT id FROM tags WHERE tag = ?", tag).Scan(&tagID)
-	if err != nil {
+	tag = Tokenize(tag)
+
+	query := "SELECT id FROM tags WHERE tag = ?"
+	tracing.Statement(ctx, query)
+	if err = r.db.QueryRowContext(ctx, query, tag).Scan(&tagID); err != nil {
 		return 0, err
 	}
 
 	return tagID, nil
 }
 
-func (r *tagsRepository) Put(tag string) (int64, error) {
-	tag = r.tokenize(tag)
+func (r *tagsRepository) Put(ctx context.Context, tag string) (id int64, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "tags", "Put")
+	defer func() { tracing.End(span, err) }()
+
+	tag = Tokenize(tag)
 
-	res, err := r.db.Exec("INSERT OR IGNORE INTO tags (tag) VALUES (?)", tag)
+	query := "INSERT OR IGNORE INTO tags (tag) VALUES (?)"
+	tracing.Statement(ctx, query)
+	res, err := r.db.ExecContext(ctx, query, tag)
 	if err != nil {
 		return 0, fmt.Errorf("could not insert tag %q: %v", tag, err)
 	}
 
-	id, err := res.LastInsertId()
+	id, err = res.LastInsertId()
 	if err != nil {
 		return 0, fmt.Errorf("could not get last inserted id when creating tag %q: %v", tag, err)
 	}
@@ -47,14 +71,392 @@ func (r *tagsRepository) Put(tag string) (int64, error) {
 	}
 
 	// the tag already existed, let's select its ID
-	if err = r.db.QueryRow("SELECT id FROM tags WHERE tag = ?", tag).Scan(&id); err != nil {
+	if err = r.db.QueryRowContext(ctx, "SELECT id FROM tags WHERE tag = ?", tag).Scan(&id); err != nil {
 		return 0, fmt.Errorf("could not get id for tag %q: %v", tag, err)
 	}
 
 	return id, nil
 }
 
-func (r *tagsRepository) tokenize(tag string) string {
+// PutMany upserts all the given tags in a single transaction and returns their IDs. Tags that are empty or
+// duplicated once tokenized are skipped so the returned IDs are unique.
+func (r *tagsRepository) PutMany(ctx context.Context, tags []string) (ids []int64, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "tags", "PutMany")
+	defer func() { tracing.End(span, err) }()
+
+	query := "INSERT OR IGNORE INTO tags (tag) VALUES (?)"
+	tracing.Statement(ctx, query)
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not start transaction for upserting tags: %v", err)
+	}
+
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = Tokenize(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+
+		if _, err := tx.ExecContext(ctx, query, tag); err != nil {
+			return nil, sqlite.RollbackTx(tx, fmt.Errorf("could not insert tag %q: %v", tag, err))
+		}
+
+		// LastInsertId can't be trusted when the insert is ignored, selecting the ID instead
+		var id int64
+		if err := tx.QueryRowContext(ctx, "SELECT id FROM tags WHERE tag = ?", tag).Scan(&id); err != nil {
+			return nil, sqlite.RollbackTx(tx, fmt.Errorf("could not get id for tag %q: %v", tag, err))
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction while upserting tags: %v", err)
+	}
+
+	return ids, nil
+}
+
+// RefreshTrending scores the tags used within the window before now and replaces the trending tags with them.
+// Every use within the window weighs half as much every half window (exponential decay), the sum of the weights is
+// the velocity of the tag. The score is the velocity relative to the usual uses of the tag, i.e. the ones between
+// the start of the baseline and the start of the window, per window: score = velocity / (1 + usual uses).
+// This way a tag used a lot all the time ranks below a tag suddenly used more than usual.
+func (r *tagsRepository) RefreshTrending(
+	ctx context.Context, now time.Time, window, baseline time.Duration,
+) (err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "tags", "RefreshTrending")
+	defer func() { tracing.End(span, err) }()
+
+	// SQLite has no exp() unless compiled with the math functions, the uses within the window are grouped by
+	// minute of age and the decay is applied below; -1 is the age of the uses before the window
+	query := `SELECT mt.tag_id,
+			CASE WHEN m.created_at > ? THEN (? - m.created_at) / 60000 ELSE -1 END AS age,
+			COUNT(*)
+		FROM message_tag AS mt
+		INNER JOIN messages AS m ON m.id = mt.message_id
+		WHERE m.created_at > ? AND m.created_at <= ? AND m.deleted_at IS NULL
+		GROUP BY mt.tag_id, age`
+	tracing.Statement(ctx, query)
+	nowMs := now.UnixMilli()
+	windowStart, baselineStart := now.Add(-window).UnixMilli(), now.Add(-baseline).UnixMilli()
+	rows, err := r.db.QueryContext(ctx, query, windowStart, nowMs, baselineStart, nowMs)
+	if err != nil {
+		return fmt.Errorf("could not get tags usage: %v", err)
+	}
+
+	type usage struct {
+		velocity float64
+		uses     int64
+		usual    int64
+	}
+	usages := make(map[int64]*usage)
+	halfLife := float64(window / 2)
+	for rows.Next() {
+		var tagID, age, count int64
+		if err := rows.Scan(&tagID, &age, &count); err != nil {
+			_ = rows.Close()
+			return fmt.Errorf("could not scan tag usage row: %v", err)
+		}
+
+		u, ok := usages[tagID]
+		if !ok {
+			u = &usage{}
+			usages[tagID] = u
+		}
+		if age < 0 {
+			u.usual += count
+			continue
+		}
+
+		// the middle of the minute is taken as the age of its uses
+		u.uses += count
+		u.velocity += float64(count) * math.Exp2(-(float64(age)+0.5)*float64(time.Minute)/halfLife)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("could not read rows: %v", err)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("could not close rows: %v", err)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not start transaction for refreshing trending tags: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM trending_tags"); err != nil {
+		return sqlite.RollbackTx(tx, fmt.Errorf("could not delete trending tags: %v", err))
+	}
+
+	windows := float64(baseline-window) / float64(window)
+	for tagID, u := range usages {
+		if u.uses == 0 {
+			continue
+		}
+
+		score := u.velocity / (1 + float64(u.usual)/windows)
+		_, err := tx.ExecContext(
+			ctx, "INSERT INTO trending_tags (tag_id, score, uses, refreshed_at) VALUES (?, ?, ?, ?)",
+			tagID, score, u.uses, nowMs,
+		)
+		if err != nil {
+			return sqlite.RollbackTx(tx, fmt.Errorf("could not insert trending tag %d: %v", tagID, err))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction while refreshing trending tags: %v", err)
+	}
+
+	return nil
+}
+
+// GetTrending returns the tags with the highest scores as of the last refresh, see RefreshTrending
+func (r *tagsRepository) GetTrending(ctx context.Context, limit int) (trending []TrendingTag, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "tags", "GetTrending")
+	defer func() { tracing.End(span, err) }()
+
+	query := `SELECT t.tag, tt.score, tt.uses FROM trending_tags AS tt
+		INNER JOIN tags AS t ON t.id = tt.tag_id
+		ORDER BY tt.score DESC, t.tag
+		LIMIT ?`
+	tracing.Statement(ctx, query)
+	rows, err := r.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not get trending tags: %v", err)
+	}
+
+	trending = []TrendingTag{}
+	for rows.Next() {
+		var tag TrendingTag
+		if err := rows.Scan(&tag.Tag, &tag.Score, &tag.Uses); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("could not scan trending tag row: %v", err)
+		}
+
+		trending = append(trending, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %v", err)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("could not close rows: %v", err)
+	}
+
+	return trending, nil
+}
+
+// List returns a page of the tags used by at least a message, sorted by name or by usage (the most used first).
+// The cursors hold the sort and the name or the uses of the tag they point to. When sorting by usage the tags move
+// as they're used, so a tag might be skipped or repeated while browsing.
+func (r *tagsRepository) List(ctx context.Context, sort Sort, page pagination.Page) (list *TagPage, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "tags", "List")
+	defer func() { tracing.End(span, err) }()
+
+	if page.Cursor != nil && page.Cursor.Sort != string(sort) {
+		return nil, fmt.Errorf("cursor issued for tags sorted by %q used to sort by %q", page.Cursor.Sort, sort)
+	}
+	backward := page.Cursor != nil && page.Cursor.Backward
+
+	query := "SELECT id, tag, uses FROM tags WHERE uses > 0"
+	var args []interface{}
+
+	switch sort {
+	case SortByUsage:
+		// the tags_uses index is sorted by uses descending and id ascending, it's read in reverse going backward
+		if page.Cursor != nil {
+			uses, err := strconv.ParseInt(page.Cursor.Key, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cursor key %q: %v", page.Cursor.Key, err)
+			}
+
+			if backward {
+				query += " AND (uses > ? OR (uses = ? AND id < ?))"
+			} else {
+				query += " AND (uses < ? OR (uses = ? AND id > ?))"
+			}
+			args = append(args, uses, uses, page.Cursor.ID)
+		}
+
+		if backward {
+			query += " ORDER BY uses ASC, id DESC"
+		} else {
+			query += " ORDER BY uses DESC, id ASC"
+		}
+	case SortByName:
+		// the tags are unique, their name is enough to find where the page starts
+		if page.Cursor != nil {
+			if backward {
+				query += " AND tag < ?"
+			} else {
+				query += " AND tag > ?"
+			}
+			args = append(args, page.Cursor.Key)
+		}
+
+		if backward {
+			query += " ORDER BY tag DESC"
+		} else {
+			query += " ORDER BY tag ASC"
+		}
+	default:
+		return nil, fmt.Errorf("unknown sort %q", sort)
+	}
+	query += fmt.Sprintf(" LIMIT %d", page.Limit+1)
+
+	tracing.Statement(ctx, query)
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not get tags: %v", err)
+	}
+
+	var tagList []Tag
+	var keys []pagination.Cursor
+	for rows.Next() {
+		var id int64
+		var tag Tag
+		if err := rows.Scan(&id, &tag.Tag, &tag.Uses); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("could not scan tag row: %v", err)
+		}
+
+		tagList = append(tagList, tag)
+		key := pagination.Cursor{ID: id, Sort: string(sort), Key: tag.Tag}
+		if sort == SortByUsage {
+			key.Key = strconv.FormatInt(tag.Uses, 10)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %v", err)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("could not close rows: %v", err)
+	}
+
+	indexes, next, prev := page.Paginate(keys)
+	list = &TagPage{
+		Tags: make([]Tag, 0, len(indexes)),
+		Next: next,
+		Prev: prev,
+	}
+	for _, i := range indexes {
+		list.Tags = append(list.Tags, tagList[i])
+	}
+
+	return list, nil
+}
+
+// Autocomplete returns the tags, used by at least a message, starting with the tokenized prefix.
+// The most used ones come first.
+func (r *tagsRepository) Autocomplete(ctx context.Context, prefix string, limit int) (suggestions []Tag, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "tags", "Autocomplete")
+	defer func() { tracing.End(span, err) }()
+
+	// underscores are allowed in tags, the wildcards of LIKE have to be escaped
+	pattern := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(Tokenize(prefix)) + "%"
+
+	query := `SELECT tag, uses FROM tags
+		WHERE tag LIKE ? ESCAPE '\' AND uses > 0
+		ORDER BY uses DESC, tag
+		LIMIT ?`
+	tracing.Statement(ctx, query)
+
+	return r.queryTags(ctx, query, pattern, limit)
+}
+
+// GetDetail returns how the tag is used along with the related tags, at most related of them.
+// sql.ErrNoRows is returned when the tag doesn't exist.
+func (r *tagsRepository) GetDetail(ctx context.Context, tag string, related int) (detail *TagDetail, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "tags", "GetDetail")
+	defer func() { tracing.End(span, err) }()
+
+	tag = Tokenize(tag)
+
+	query := `SELECT t.id, t.tag, t.uses, MIN(m.created_at), MAX(m.created_at) FROM tags AS t
+		LEFT JOIN message_tag AS mt ON mt.tag_id = t.id
+		LEFT JOIN messages AS m ON m.id = mt.message_id AND m.deleted_at IS NULL
+		WHERE t.tag = ?
+		GROUP BY t.id`
+	tracing.Statement(ctx, query)
+	var tagID int64
+	var firstSeen, lastUsed sql.NullInt64
+	detail = &TagDetail{}
+	err = r.db.QueryRowContext(ctx, query, tag).Scan(&tagID, &detail.Tag.Tag, &detail.Uses, &firstSeen, &lastUsed)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("could not get tag %q: %v", tag, err)
+	}
+
+	if firstSeen.Valid {
+		detail.FirstSeen = formatTime(firstSeen.Int64)
+		detail.LastUsed = formatTime(lastUsed.Int64)
+	}
+
+	query = `SELECT t.tag, COUNT(*) AS uses FROM message_tag AS mt
+		INNER JOIN message_tag AS other ON other.message_id = mt.message_id AND other.tag_id != mt.tag_id
+		INNER JOIN messages AS m ON m.id = mt.message_id
+		INNER JOIN tags AS t ON t.id = other.tag_id
+		WHERE mt.tag_id = ? AND m.deleted_at IS NULL
+		GROUP BY t.id
+		ORDER BY uses DESC, t.tag
+		LIMIT ?`
+	tracing.Statement(ctx, query)
+	if detail.Related, err = r.queryTags(ctx, query, tagID, related); err != nil {
+		return nil, fmt.Errorf("could not get tags related to %q: %v", tag, err)
+	}
+
+	return detail, nil
+}
+
+// queryTags runs a query selecting the tag and its uses, an empty slice is returned when no row is found
+func (r *tagsRepository) queryTags(ctx context.Context, query string, args ...interface{}) ([]Tag, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not get tags: %v", err)
+	}
+
+	tagList := []Tag{}
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.Tag, &tag.Uses); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("could not scan tag row: %v", err)
+		}
+
+		tagList = append(tagList, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %v", err)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("could not close rows: %v", err)
+	}
+
+	return tagList, nil
+}
+
+// formatTime formats unix milliseconds the way the messages times are
+func formatTime(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(messages.TimeLayout)
+}
+
+// MaxLength is the maximum length of a tokenized tag, in characters
+const MaxLength = 50
+
+// Tokenize normalizes a tag the way it's stored, all the methods of the repository tokenize the tags they receive
+func Tokenize(tag string) string {
 	// let's tokenize the tag to avoid duplicates as much as possible
 	// this could potentially be more complicated but for now I'll
 	// just make it lowercase and I'll replace spaces with dashes
@@ -64,6 +466,21 @@ func (r *tagsRepository) tokenize(tag string) string {
 	return tag
 }
 
+// Valid tells whether a tokenized tag is made of 1 to MaxLength letters, digits, underscores or dashes
+func Valid(tag string) bool {
+	if tag == "" || utf8.RuneCountInString(tag) > MaxLength {
+		return false
+	}
+
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r) && r != '_' && r != '-' {
+			return false
+		}
+	}
+
+	return true
+}
+
 func New(db *sql.DB) Repository {
 	return &tagsRepository{
 		db: db,
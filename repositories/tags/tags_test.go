@@ -1,10 +1,21 @@
 package tags
 
 import (
+	"context"
+	"database/sql"
+	"go-twitter-test/pagination"
 	"go-twitter-test/repositories/testutils"
+	"math"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func TestTagsRepository(t *testing.T) {
@@ -13,23 +24,295 @@ func TestTagsRepository(t *testing.T) {
 	defer testutils.TearDown(t, db, []string{dbDsn})
 
 	repo := New(db)
-	tagID, err := repo.Put("A Nice Tag") // expected: a-nice-tag
+	ctx := context.Background()
+	tagID, err := repo.Put(ctx, "A Nice Tag") // expected: a-nice-tag
 	require.Nil(t, err)
 	require.EqualValues(t, 1, tagID)
 
-	tagID, err = repo.Put(" A-NICE tag   ") // expected: a-nice-tag
+	tagID, err = repo.Put(ctx, " A-NICE tag   ") // expected: a-nice-tag
 	require.Nil(t, err)
 	require.EqualValues(t, 1, tagID) // same tag as before, ID should still be 1
 
-	tagID, err = repo.Put("a different tag") // expected: a-different-tag
+	tagID, err = repo.Put(ctx, "a different tag") // expected: a-different-tag
 	require.Nil(t, err)
 	require.EqualValues(t, 2, tagID)
 
-	tagID, err = repo.GetID("a nice tag")
+	tagID, err = repo.GetID(ctx, "a nice tag")
 	require.Nil(t, err)
 	require.EqualValues(t, 1, tagID)
 
-	tagID, err = repo.GetID("a different tag")
+	tagID, err = repo.GetID(ctx, "a different tag")
 	require.Nil(t, err)
 	require.EqualValues(t, 2, tagID)
+
+	tagIDs, err := repo.PutMany(ctx, []string{"A nice tag", "a third tag", "  ", "A THIRD TAG"})
+	require.Nil(t, err)
+	require.Equal(t, []int64{1, 3}, tagIDs) // existing tag, new tag, empty tag and duplicate skipped
+}
+
+func TestTagsRepository_Tracing(t *testing.T) {
+	const dbDsn = "./testdata/test2.db"
+	db := testutils.SetUp(t, dbDsn)
+	defer testutils.TearDown(t, db, []string{dbDsn})
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	repo := New(db)
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	_, err := repo.Put(ctx, "golang")
+	require.Nil(t, err)
+	_, err = repo.GetID(ctx, "rust")
+	require.Equal(t, sql.ErrNoRows, err)
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+
+	put := spans[0]
+	require.Equal(t, "tags.Put", put.Name())
+	require.Equal(t, parent.SpanContext().SpanID(), put.Parent().SpanID())
+	require.Contains(t, put.Attributes(), attribute.String("db.system", "sqlite"))
+	require.Contains(t, put.Attributes(), attribute.String("db.statement", "INSERT OR IGNORE INTO tags (tag) VALUES (?)"))
+
+	// a tag not found is not a failure
+	getID := spans[1]
+	require.Equal(t, "tags.GetID", getID.Name())
+	require.Contains(t, getID.Attributes(), attribute.String("db.statement", "SELECT id FROM tags WHERE tag = ?"))
+	require.Equal(t, codes.Unset, getID.Status().Code)
+}
+
+func TestTagsRepository_Trending(t *testing.T) {
+	const dbDsn = "./testdata/test3.db"
+	db := testutils.SetUp(t, dbDsn)
+	defer testutils.TearDown(t, db, []string{dbDsn})
+
+	_, err := db.Exec("INSERT INTO users (id, email) VALUES (1, 'user@email.com')")
+	require.Nil(t, err)
+	_, err = db.Exec("INSERT INTO tags (id, tag) VALUES (1, 'steady'), (2, 'surging'), (3, 'old'), (4, 'deleted')")
+	require.Nil(t, err)
+
+	now := time.Date(2019, 9, 8, 12, 0, 0, 0, time.UTC)
+	uses := []struct {
+		tagID   int64
+		age     time.Duration
+		deleted bool
+	}{
+		// used every day of the baseline and once within the window
+		{1, time.Hour, false},
+		{1, 2 * 24 * time.Hour, false},
+		{1, 3 * 24 * time.Hour, false},
+		{1, 4 * 24 * time.Hour, false},
+		{1, 5 * 24 * time.Hour, false},
+		{1, 6 * 24 * time.Hour, false},
+		// never used before
+		{2, time.Hour, false},
+		{2, 2 * time.Hour, false},
+		{2, 20 * time.Hour, false},
+		// not used within the window
+		{3, 2 * 24 * time.Hour, false},
+		{3, 8 * 24 * time.Hour, false},
+		{4, time.Hour, true},
+	}
+	for i, use := range uses {
+		var deletedAt interface{}
+		if use.deleted {
+			deletedAt = now.UnixMilli()
+		}
+		_, err := db.Exec(
+			`INSERT INTO messages (id, user_id, message, created_at, conversation_id, deleted_at)
+			VALUES (?, 1, 'message', ?, ?, ?)`,
+			i+1, now.Add(-use.age).UnixMilli(), i+1, deletedAt,
+		)
+		require.Nil(t, err)
+		_, err = db.Exec("INSERT INTO message_tag (message_id, tag_id) VALUES (?, ?)", i+1, use.tagID)
+		require.Nil(t, err)
+	}
+
+	repo := New(db)
+	ctx := context.Background()
+	trending, err := repo.GetTrending(ctx, 10)
+	require.Nil(t, err)
+	require.Empty(t, trending, "nothing is trending before the first refresh")
+
+	require.Nil(t, repo.RefreshTrending(ctx, now, 24*time.Hour, 7*24*time.Hour))
+	trending, err = repo.GetTrending(ctx, 10)
+	require.Nil(t, err)
+	require.Len(t, trending, 2)
+
+	// the uses weigh half as much every 12 hours, the steady tag is used about once per window usually
+	decay := func(age time.Duration) float64 {
+		return math.Exp2(-float64(age+30*time.Second) / float64(12*time.Hour))
+	}
+	require.Equal(t, "surging", trending[0].Tag)
+	require.EqualValues(t, 3, trending[0].Uses)
+	require.InDelta(t, decay(time.Hour)+decay(2*time.Hour)+decay(20*time.Hour), trending[0].Score, 1e-9)
+	require.Equal(t, "steady", trending[1].Tag)
+	require.EqualValues(t, 1, trending[1].Uses)
+	require.InDelta(t, decay(time.Hour)/(1+5.0/6), trending[1].Score, 1e-9)
+
+	trending, err = repo.GetTrending(ctx, 1)
+	require.Nil(t, err)
+	require.Len(t, trending, 1)
+
+	// every refresh replaces the trending tags
+	require.Nil(t, repo.RefreshTrending(ctx, now.Add(2*24*time.Hour), 24*time.Hour, 7*24*time.Hour))
+	trending, err = repo.GetTrending(ctx, 10)
+	require.Nil(t, err)
+	require.Empty(t, trending)
+}
+
+func TestTagsRepository_Browse(t *testing.T) {
+	const dbDsn = "./testdata/test4.db"
+	db := testutils.SetUp(t, dbDsn)
+	defer testutils.TearDown(t, db, []string{dbDsn})
+
+	_, err := db.Exec("INSERT INTO users (id, email) VALUES (1, 'user@email.com')")
+	require.Nil(t, err)
+	_, err = db.Exec(`INSERT INTO tags (id, tag) VALUES
+		(1, 'golang'), (2, 'go_modules'), (3, 'gophers'), (4, 'sqlite'), (5, 'goroutines'), (6, 'unused')`)
+	require.Nil(t, err)
+
+	start := time.Date(2019, 9, 1, 10, 0, 0, 0, time.UTC)
+	msgs := []struct {
+		tagIDs  []int64
+		deleted bool
+	}{
+		{[]int64{1, 4}, false},
+		{[]int64{1, 3}, false},
+		{[]int64{1, 4}, false},
+		{[]int64{2, 1}, false},
+		{[]int64{4}, false},
+		{[]int64{3}, false},
+		{[]int64{3}, false},
+		// deleted messages don't count
+		{[]int64{5, 1}, true},
+	}
+	for i, msg := range msgs {
+		var deletedAt interface{}
+		if msg.deleted {
+			deletedAt = start.UnixMilli()
+		}
+		_, err := db.Exec(
+			`INSERT INTO messages (id, user_id, message, created_at, conversation_id, deleted_at)
+			VALUES (?, 1, 'message', ?, ?, ?)`,
+			i+1, start.Add(time.Duration(i)*time.Hour).UnixMilli(), i+1, deletedAt,
+		)
+		require.Nil(t, err)
+		for _, tagID := range msg.tagIDs {
+			_, err = db.Exec("INSERT INTO message_tag (message_id, tag_id) VALUES (?, ?)", i+1, tagID)
+			require.Nil(t, err)
+		}
+	}
+
+	repo := New(db)
+	ctx := context.Background()
+
+	// browsing back and forth, sorted by name
+	page, err := repo.List(ctx, SortByName, pagination.Page{Limit: 2})
+	require.Nil(t, err)
+	require.Equal(t, []Tag{{"go_modules", 1}, {"golang", 4}}, page.Tags)
+	require.Nil(t, page.Prev)
+	page, err = repo.List(ctx, SortByName, pagination.Page{Limit: 2, Cursor: page.Next})
+	require.Nil(t, err)
+	require.Equal(t, []Tag{{"gophers", 3}, {"sqlite", 3}}, page.Tags)
+	require.Nil(t, page.Next)
+	page, err = repo.List(ctx, SortByName, pagination.Page{Limit: 2, Cursor: page.Prev})
+	require.Nil(t, err)
+	require.Equal(t, []Tag{{"go_modules", 1}, {"golang", 4}}, page.Tags)
+
+	// sorted by usage, the tags used as much are sorted by ID
+	page, err = repo.List(ctx, SortByUsage, pagination.Page{Limit: 2})
+	require.Nil(t, err)
+	require.Equal(t, []Tag{{"golang", 4}, {"gophers", 3}}, page.Tags)
+	page, err = repo.List(ctx, SortByUsage, pagination.Page{Limit: 2, Cursor: page.Next})
+	require.Nil(t, err)
+	require.Equal(t, []Tag{{"sqlite", 3}, {"go_modules", 1}}, page.Tags)
+	require.Nil(t, page.Next)
+	page, err = repo.List(ctx, SortByUsage, pagination.Page{Limit: 2, Cursor: page.Prev})
+	require.Nil(t, err)
+	require.Equal(t, []Tag{{"golang", 4}, {"gophers", 3}}, page.Tags)
+
+	_, err = repo.List(ctx, Sort("date"), pagination.Page{Limit: 2})
+	require.NotNil(t, err)
+
+	// a cursor can't be used with another sort
+	page, err = repo.List(ctx, SortByName, pagination.Page{Limit: 2})
+	require.Nil(t, err)
+	_, err = repo.List(ctx, SortByUsage, pagination.Page{Limit: 2, Cursor: page.Next})
+	require.NotNil(t, err)
+
+	// the prefix is tokenized and the underscore is not a wildcard
+	suggestions, err := repo.Autocomplete(ctx, " GO", 10)
+	require.Nil(t, err)
+	require.Equal(t, []Tag{{"golang", 4}, {"gophers", 3}, {"go_modules", 1}}, suggestions)
+	suggestions, err = repo.Autocomplete(ctx, "go_", 10)
+	require.Nil(t, err)
+	require.Equal(t, []Tag{{"go_modules", 1}}, suggestions)
+	suggestions, err = repo.Autocomplete(ctx, "go", 1)
+	require.Nil(t, err)
+	require.Equal(t, []Tag{{"golang", 4}}, suggestions)
+	suggestions, err = repo.Autocomplete(ctx, "rust", 10)
+	require.Nil(t, err)
+	require.Empty(t, suggestions)
+
+	detail, err := repo.GetDetail(ctx, "GoLang", 10)
+	require.Nil(t, err)
+	require.Equal(t, &TagDetail{
+		Tag:       Tag{"golang", 4},
+		FirstSeen: "2019-09-01T10:00:00.000Z",
+		LastUsed:  "2019-09-01T13:00:00.000Z",
+		Related:   []Tag{{"sqlite", 2}, {"go_modules", 1}, {"gophers", 1}},
+	}, detail)
+
+	detail, err = repo.GetDetail(ctx, "golang", 1)
+	require.Nil(t, err)
+	require.Equal(t, []Tag{{"sqlite", 2}}, detail.Related)
+
+	// tags never used (or only by deleted messages) exist nonetheless
+	detail, err = repo.GetDetail(ctx, "goroutines", 10)
+	require.Nil(t, err)
+	require.Equal(t, &TagDetail{Tag: Tag{"goroutines", 0}, Related: []Tag{}}, detail)
+
+	_, err = repo.GetDetail(ctx, "rust", 10)
+	require.Equal(t, sql.ErrNoRows, err)
+
+	// the uses follow the messages being deleted, restored and re-tagged
+	uses := func(tag string) int64 {
+		detail, err := repo.GetDetail(ctx, tag, 0)
+		require.Nil(t, err)
+		return detail.Uses
+	}
+	_, err = db.Exec("UPDATE messages SET deleted_at = 1 WHERE id = 1")
+	require.Nil(t, err)
+	require.EqualValues(t, 3, uses("golang"))
+	require.EqualValues(t, 2, uses("sqlite"))
+	_, err = db.Exec("UPDATE messages SET deleted_at = NULL WHERE id IN (1, 8)")
+	require.Nil(t, err)
+	require.EqualValues(t, 5, uses("golang"))
+	require.EqualValues(t, 1, uses("goroutines"))
+	_, err = db.Exec("DELETE FROM message_tag WHERE message_id = 2 AND tag_id = 1")
+	require.Nil(t, err)
+	require.EqualValues(t, 4, uses("golang"))
+	_, err = db.Exec("DELETE FROM messages WHERE id = 3")
+	require.Nil(t, err)
+	require.EqualValues(t, 3, uses("golang"))
+	require.EqualValues(t, 2, uses("sqlite"))
+}
+
+func TestValid(t *testing.T) {
+	for tag, valid := range map[string]bool{
+		Tokenize("A Nice Tag"):  true,
+		"snake_case":            true,
+		"café":                  true,
+		"2019":                  true,
+		"":                      false,
+		"not-ok!":               false,
+		"#hashtag":              false,
+		strings.Repeat("a", 50): true,
+		strings.Repeat("a", 51): false,
+		strings.Repeat("é", 50): true,
+	} {
+		require.Equal(t, valid, Valid(tag), tag)
+	}
 }
@@ -13,7 +13,8 @@ func SetUp(t *testing.T, dbDsn string) *sql.DB {
 	db, err := sqlite.New(dbDsn)
 	require.Nil(t, err)
 
-	err = sqlite.LoadSchema(db)
+	// using the same migrations as production so that tests run against the same schema
+	_, err = sqlite.Migrate(db)
 	require.Nil(t, err)
 
 	return db
@@ -1,6 +1,39 @@
 package users
 
+import "go-twitter-test/pagination"
+
+// User is a registered user, users created before handles were introduced may have an empty Handle.
+// CreatedAt is formatted with messages.TimeLayout.
 type User struct {
-	ID    int64
-	Email string
+	ID          int64  `json:"id"`
+	Email       string `json:"email,omitempty"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// UserCreate is a model used when registering a new user (see POST /v1/users)
+type UserCreate struct {
+	Email       string
+	Handle      string
+	DisplayName string
+	Bio         string
+	AvatarURL   string
+}
+
+// UserUpdate is a model used when updating a user profile (see PATCH /v1/users/{id}), nil fields are left untouched
+type UserUpdate struct {
+	Handle      *string
+	DisplayName *string
+	Bio         *string
+	AvatarURL   *string
+}
+
+// UserPage is a page of users along with the cursors pointing to the pages next to it (nil if there's none)
+type UserPage struct {
+	Users []User
+	Next  *pagination.Cursor
+	Prev  *pagination.Cursor
 }
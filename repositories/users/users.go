@@ -1,33 +1,283 @@
 package users
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"go-twitter-test/pagination"
+	"go-twitter-test/repositories/messages"
+	"go-twitter-test/tracing"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
 )
 
+// ErrEmailTaken is returned when creating a user with an email that belongs to another user
+var ErrEmailTaken = errors.New("email already taken")
+
+// ErrHandleTaken is returned when creating or updating a user with a handle that belongs to another user
+var ErrHandleTaken = errors.New("handle already taken")
+
 //go:generate counterfeiter . Repository
 type Repository interface {
-	Get(userID int64) (*User, error)
+	Get(ctx context.Context, userID int64) (*User, error)
+	Create(ctx context.Context, user UserCreate) (int64, error)
+	Update(ctx context.Context, userID int64, update UserUpdate) error
+	Follow(ctx context.Context, followerID, followeeID int64) error
+	Unfollow(ctx context.Context, followerID, followeeID int64) error
+	GetFollowers(ctx context.Context, userID int64, page pagination.Page) (*UserPage, error)
+	GetFollowing(ctx context.Context, userID int64, page pagination.Page) (*UserPage, error)
 }
 
 type userRepository struct {
 	db *sql.DB
 }
 
-func (r *userRepository) Get(userID int64) (*User, error) {
+func (r *userRepository) Get(ctx context.Context, userID int64) (user *User, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "users", "Get")
+	defer func() { tracing.End(span, err) }()
+
 	if userID == 0 {
 		return nil, fmt.Errorf("empty user ID supplied")
 	}
 
-	user := User{}
-	err := r.db.QueryRow("SELECT id, email FROM users WHERE id = ?", userID).Scan(&user.ID, &user.Email)
+	query := "SELECT " + userColumns + " FROM users AS u WHERE u.id = ?"
+	tracing.Statement(ctx, query)
+	return scanUser(r.db.QueryRowContext(ctx, query, userID))
+}
+
+func (r *userRepository) Create(ctx context.Context, user UserCreate) (userID int64, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "users", "Create")
+	defer func() { tracing.End(span, err) }()
+
+	query := `INSERT INTO users (email, handle, display_name, bio, avatar_url, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`
+	tracing.Statement(ctx, query)
+	res, err := r.db.ExecContext(
+		ctx, query,
+		user.Email, nullable(user.Handle), user.DisplayName, user.Bio, user.AvatarURL, time.Now().UnixMilli(),
+	)
+	if err != nil {
+		if uniqueErr := uniqueViolation(err); uniqueErr != nil {
+			return 0, uniqueErr
+		}
+
+		return 0, fmt.Errorf("could not create user: %v", err)
+	}
+
+	userID, err = res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("could not get last inserted user ID: %v", err)
+	}
+
+	return userID, nil
+}
+
+// Update changes the profile fields that are not nil, sql.ErrNoRows is returned if the user does not exist
+func (r *userRepository) Update(ctx context.Context, userID int64, update UserUpdate) (err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "users", "Update")
+	defer func() { tracing.End(span, err) }()
+
+	var sets []string
+	var args []interface{}
+	if update.Handle != nil {
+		sets = append(sets, "handle = ?")
+		args = append(args, nullable(*update.Handle))
+	}
+	if update.DisplayName != nil {
+		sets = append(sets, "display_name = ?")
+		args = append(args, *update.DisplayName)
+	}
+	if update.Bio != nil {
+		sets = append(sets, "bio = ?")
+		args = append(args, *update.Bio)
+	}
+	if update.AvatarURL != nil {
+		sets = append(sets, "avatar_url = ?")
+		args = append(args, *update.AvatarURL)
+	}
+
+	if len(sets) == 0 {
+		// nothing to update, still making sure the user exists
+		_, err = r.Get(ctx, userID)
+		return err
+	}
+
+	query := "UPDATE users SET " + strings.Join(sets, ", ") + " WHERE id = ?"
+	tracing.Statement(ctx, query)
+	res, err := r.db.ExecContext(ctx, query, append(args, userID)...)
+	if err != nil {
+		if uniqueErr := uniqueViolation(err); uniqueErr != nil {
+			return uniqueErr
+		}
+
+		return fmt.Errorf("could not update user %d: %v", userID, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get affected rows when updating user %d: %v", userID, err)
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Follow makes the follower follow the followee, following someone twice is a no-op
+func (r *userRepository) Follow(ctx context.Context, followerID, followeeID int64) (err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "users", "Follow")
+	defer func() { tracing.End(span, err) }()
+
+	query := "INSERT OR IGNORE INTO follows (follower_id, followee_id, created_at) VALUES (?, ?, ?)"
+	tracing.Statement(ctx, query)
+	if _, err = r.db.ExecContext(ctx, query, followerID, followeeID, time.Now().UnixMilli()); err != nil {
+		return fmt.Errorf("could not make user %d follow user %d: %v", followerID, followeeID, err)
+	}
+
+	return nil
+}
+
+// Unfollow makes the follower stop following the followee, unfollowing someone not followed is a no-op
+func (r *userRepository) Unfollow(ctx context.Context, followerID, followeeID int64) (err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "users", "Unfollow")
+	defer func() { tracing.End(span, err) }()
+
+	query := "DELETE FROM follows WHERE follower_id = ? AND followee_id = ?"
+	tracing.Statement(ctx, query)
+	if _, err = r.db.ExecContext(ctx, query, followerID, followeeID); err != nil {
+		return fmt.Errorf("could not make user %d unfollow user %d: %v", followerID, followeeID, err)
+	}
+
+	return nil
+}
+
+// GetFollowers returns the users following userID, sorted by when they started following
+func (r *userRepository) GetFollowers(
+	ctx context.Context, userID int64, page pagination.Page,
+) (users *UserPage, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "users", "GetFollowers")
+	defer func() { tracing.End(span, err) }()
+
+	return r.followsQuery(ctx, "f.follower_id", "f.followee_id", userID, page)
+}
+
+// GetFollowing returns the users followed by userID, sorted by when they've been followed
+func (r *userRepository) GetFollowing(
+	ctx context.Context, userID int64, page pagination.Page,
+) (users *UserPage, err error) {
+	ctx, span := tracing.StartRepositorySpan(ctx, "users", "GetFollowing")
+	defer func() { tracing.End(span, err) }()
+
+	return r.followsQuery(ctx, "f.followee_id", "f.follower_id", userID, page)
+}
+
+// followsQuery lists the users on the listed side of the follows table given the user on the other side
+func (r *userRepository) followsQuery(
+	ctx context.Context, listedColumn, userColumn string, userID int64, page pagination.Page,
+) (*UserPage, error) {
+	query := "SELECT " + userColumns + ", f.created_at FROM follows AS f " +
+		"INNER JOIN users AS u ON u.id = " + listedColumn + " WHERE " + userColumn + " = ?"
+	args := []interface{}{userID}
+
+	if condition, conditionArgs := page.Condition("f.created_at", "u.id"); condition != "" {
+		query += " AND " + condition
+		args = append(args, conditionArgs...)
+	}
+	query += page.OrderBy("f.created_at", "u.id")
+
+	tracing.Statement(ctx, query)
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
+		return nil, fmt.Errorf("could not get follows of user %d: %v", userID, err)
+	}
+
+	var list []User
+	var keys []pagination.Cursor
+	for rows.Next() {
+		var followedAt int64
+		user, err := scanUser(rows, &followedAt)
+		if err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("could not scan user row: %v", err)
+		}
+
+		list = append(list, *user)
+		keys = append(keys, pagination.Cursor{CreatedAt: followedAt, ID: user.ID})
+	}
+
+	// Next returns false on errors too, e.g. when the query is interrupted because the context is done
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %v", err)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("could not close rows: %v", err)
+	}
+
+	indexes, next, prev := page.Paginate(keys)
+	result := &UserPage{
+		Users: make([]User, 0, len(indexes)),
+		Next:  next,
+		Prev:  prev,
+	}
+	for _, i := range indexes {
+		result.Users = append(result.Users, list[i])
+	}
+
+	return result, nil
+}
+
+// userColumns are the columns read by scanUser
+const userColumns = "u.id, u.email, u.handle, u.display_name, u.bio, u.avatar_url, u.created_at"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser scans a row selected with userColumns, extra destinations can be passed for the columns selected after
+func scanUser(s scanner, extra ...interface{}) (*User, error) {
+	user := User{}
+	var handle sql.NullString
+	var createdAt int64
+	dest := append([]interface{}{
+		&user.ID, &user.Email, &handle, &user.DisplayName, &user.Bio, &user.AvatarURL, &createdAt,
+	}, extra...)
+	if err := s.Scan(dest...); err != nil {
 		return nil, err
 	}
 
+	user.Handle = handle.String
+	user.CreatedAt = time.UnixMilli(createdAt).UTC().Format(messages.TimeLayout)
+
 	return &user, nil
 }
 
+// nullable stores empty handles as NULL so that they don't violate the unique index
+func nullable(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// uniqueViolation maps the UNIQUE constraint errors to ErrEmailTaken or ErrHandleTaken, nil is returned for
+// any other error
+func uniqueViolation(err error) error {
+	sqliteErr, ok := err.(sqlite3.Error)
+	if !ok || sqliteErr.ExtendedCode != sqlite3.ErrConstraintUnique {
+		return nil
+	}
+
+	switch {
+	case strings.Contains(sqliteErr.Error(), "users.email"):
+		return ErrEmailTaken
+	case strings.Contains(sqliteErr.Error(), "users.handle"):
+		return ErrHandleTaken
+	}
+
+	return nil
+}
+
 func New(db *sql.DB) Repository {
 	return &userRepository{
 		db: db,
@@ -1,9 +1,12 @@
 package users
 
 import (
+	"context"
 	"database/sql"
+	"go-twitter-test/pagination"
 	"go-twitter-test/repositories/testutils"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -16,15 +19,109 @@ func TestUserRepository_Get(t *testing.T) {
 	loadFixtures(t, db)
 
 	repo := New(db)
-	user, err := repo.Get(1)
+	ctx := context.Background()
+	user, err := repo.Get(ctx, 1)
 
 	require.Nil(t, err)
 	require.Equal(t, &User{
-		ID:    1,
-		Email: "test@email.com",
+		ID:        1,
+		Email:     "test@email.com",
+		CreatedAt: "1970-01-01T00:00:00.000Z",
 	}, user)
 }
 
+func TestUserRepository_CreateAndUpdate(t *testing.T) {
+	const dbDsn = "./testdata/test2.db"
+	db := testutils.SetUp(t, dbDsn)
+	defer testutils.TearDown(t, db, []string{dbDsn})
+
+	loadFixtures(t, db)
+
+	repo := New(db)
+	ctx := context.Background()
+	userID, err := repo.Create(ctx, UserCreate{
+		Email:       "gopher@email.com",
+		Handle:      "Gopher",
+		DisplayName: "The Gopher",
+	})
+	require.Nil(t, err)
+	require.EqualValues(t, 2, userID)
+
+	user, err := repo.Get(ctx, userID)
+	require.Nil(t, err)
+	require.Equal(t, "Gopher", user.Handle)
+	require.Equal(t, "The Gopher", user.DisplayName)
+	createdAt, err := time.Parse(time.RFC3339, user.CreatedAt)
+	require.Nil(t, err)
+	require.WithinDuration(t, time.Now(), createdAt, 2*time.Second)
+
+	_, err = repo.Create(ctx, UserCreate{Email: "test@email.com", Handle: "another"})
+	require.Equal(t, ErrEmailTaken, err)
+
+	_, err = repo.Create(ctx, UserCreate{Email: "another@email.com", Handle: "gOpHeR"}) // handles are case insensitive
+	require.Equal(t, ErrHandleTaken, err)
+
+	bio := "Digging holes"
+	err = repo.Update(ctx, userID, UserUpdate{Bio: &bio})
+	require.Nil(t, err)
+
+	user, err = repo.Get(ctx, userID)
+	require.Nil(t, err)
+	require.Equal(t, "Digging holes", user.Bio)
+	require.Equal(t, "The Gopher", user.DisplayName) // untouched
+
+	handle := "gopher"
+	err = repo.Update(ctx, 1, UserUpdate{Handle: &handle})
+	require.Equal(t, ErrHandleTaken, err)
+
+	err = repo.Update(ctx, 3, UserUpdate{Bio: &bio})
+	require.Equal(t, sql.ErrNoRows, err)
+}
+
+func TestUserRepository_Follows(t *testing.T) {
+	const dbDsn = "./testdata/test3.db"
+	db := testutils.SetUp(t, dbDsn)
+	defer testutils.TearDown(t, db, []string{dbDsn})
+
+	_, err := db.Exec("INSERT INTO users (id, email) VALUES (1, 'a@email.com'), (2, 'b@email.com'), (3, 'c@email.com')")
+	require.Nil(t, err)
+
+	repo := New(db)
+	ctx := context.Background()
+	require.Nil(t, repo.Follow(ctx, 1, 2))
+	require.Nil(t, repo.Follow(ctx, 1, 2)) // idempotent
+	require.Nil(t, repo.Follow(ctx, 1, 3))
+	require.Nil(t, repo.Follow(ctx, 3, 2))
+
+	page := pagination.Page{Limit: 1}
+	following, err := repo.GetFollowing(ctx, 1, page)
+	require.Nil(t, err)
+	require.Len(t, following.Users, 1)
+	require.EqualValues(t, 2, following.Users[0].ID)
+	require.NotNil(t, following.Next)
+
+	page.Cursor = following.Next
+	following, err = repo.GetFollowing(ctx, 1, page)
+	require.Nil(t, err)
+	require.Len(t, following.Users, 1)
+	require.EqualValues(t, 3, following.Users[0].ID)
+	require.Nil(t, following.Next)
+
+	followers, err := repo.GetFollowers(ctx, 2, pagination.Page{Limit: 10})
+	require.Nil(t, err)
+	require.Len(t, followers.Users, 2)
+	require.EqualValues(t, 1, followers.Users[0].ID)
+	require.EqualValues(t, 3, followers.Users[1].ID)
+
+	require.Nil(t, repo.Unfollow(ctx, 1, 2))
+	require.Nil(t, repo.Unfollow(ctx, 1, 2)) // idempotent
+
+	followers, err = repo.GetFollowers(ctx, 2, pagination.Page{Limit: 10})
+	require.Nil(t, err)
+	require.Len(t, followers.Users, 1)
+	require.EqualValues(t, 3, followers.Users[0].ID)
+}
+
 func loadFixtures(t *testing.T, db *sql.DB) {
 	_, err := db.Exec("INSERT INTO users (id, email) VALUES (?, ?)", 1, "test@email.com")
 	require.Nil(t, err)
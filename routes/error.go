@@ -1,40 +1,74 @@
 package routes
 
 import (
+	"encoding/json"
 	"net/http"
+	"strings"
 
-	"github.com/go-chi/render"
+	"github.com/go-chi/chi/middleware"
 )
 
-type Error struct {
-	Code         int    `json:"code"`
-	Description  string `json:"description"`
-	ReasonPhrase string `json:"reasonPhrase"`
+// problemContentType is the media type of the error responses, see https://tools.ietf.org/html/rfc7807
+const problemContentType = "application/problem+json"
+
+// validationProblemType is the type of the problems listing the fields that failed the validation
+const validationProblemType = "/problems/validation-error"
+
+// Problem is the body of every error response (RFC 7807 problem details).
+// Type identifies the kind of problem, Instance is the ID of the request so that the problem can be found in the logs.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Errors lists the fields of the request body that failed the validation, if any
+	Errors []FieldError `json:"errors,omitempty"`
 }
 
+// RenderError renders a problem with the given status code, message being its detail
 func RenderError(w http.ResponseWriter, r *http.Request, message string, statusCode int) {
-	reasonPhrase := ""
-	switch statusCode {
-	case http.StatusBadRequest:
-		reasonPhrase = "Bad Request"
-	case http.StatusInternalServerError:
-		reasonPhrase = "Internal Server Error"
-	case http.StatusRequestEntityTooLarge:
-		reasonPhrase = "Request Entity Too Large"
-	case http.StatusNotFound:
-		reasonPhrase = "Not Found"
-	case http.StatusUnauthorized:
-		reasonPhrase = "Unauthorized"
-	case http.StatusForbidden:
-		reasonPhrase = "Forbidden"
-	default:
-		reasonPhrase = "Unknown error"
-	}
+	renderProblem(w, r, &Problem{
+		Type:   problemType(statusCode),
+		Title:  http.StatusText(statusCode),
+		Status: statusCode,
+		Detail: message,
+	})
+}
 
-	render.Status(r, statusCode)
-	render.JSON(w, r, &Error{
-		Code:         statusCode,
-		Description:  message,
-		ReasonPhrase: reasonPhrase,
+// RenderValidationError renders a 400 listing the fields of the request body that failed the validation
+func RenderValidationError(w http.ResponseWriter, r *http.Request, message string, errs []FieldError) {
+	renderProblem(w, r, &Problem{
+		Type:   validationProblemType,
+		Title:  "Validation Error",
+		Status: http.StatusBadRequest,
+		Detail: message,
+		Errors: errs,
 	})
 }
+
+func renderProblem(w http.ResponseWriter, r *http.Request, problem *Problem) {
+	problem.Instance = middleware.GetReqID(r.Context())
+
+	body, err := json.Marshal(problem)
+	if err != nil {
+		// a problem is made of strings and ints only, this can't really happen
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(problem.Status)
+	_, _ = w.Write(body)
+}
+
+// problemType returns the type URI of a problem with no more specific semantics than its status code,
+// e.g. /problems/not-found for a 404
+func problemType(statusCode int) string {
+	title := http.StatusText(statusCode)
+	if title == "" {
+		return "about:blank"
+	}
+
+	return "/problems/" + strings.ReplaceAll(strings.ToLower(title), " ", "-")
+}
@@ -0,0 +1,52 @@
+package routes
+
+import (
+	"encoding/json"
+	"go-twitter-test/container/mock"
+	"go-twitter-test/repositories/messages/messagesfakes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderError(t *testing.T) {
+	c := mock.NewMockedContainer()
+	c.MessagesRepositoryReturns(&messagesfakes.FakeRepository{})
+	router := NewRouter(c)
+
+	for name, tc := range map[string]struct {
+		method      string
+		url         string
+		contentType string
+		status      int
+		problemType string
+	}{
+		"handler error":      {"GET", "/v1/messages?limit=abc", "application/json", 400, "/problems/bad-request"},
+		"unknown route":      {"GET", "/v1/unknown", "application/json", 404, "/problems/not-found"},
+		"invalid route ID":   {"GET", "/v1/messages/abc", "application/json", 404, "/problems/not-found"},
+		"method not allowed": {"PUT", "/v1/messages", "application/json", 405, "/problems/method-not-allowed"},
+		"content type":       {"POST", "/v1/messages", "text/plain", 415, "/problems/unsupported-media-type"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			request, err := http.NewRequest(tc.method, tc.url, strings.NewReader("{}"))
+			require.Nil(t, err)
+			request.Header.Set("Content-Type", tc.contentType)
+
+			responseRecorder := httptest.NewRecorder()
+			router.ServeHTTP(responseRecorder, request)
+
+			require.Equal(t, tc.status, responseRecorder.Code)
+			require.Equal(t, problemContentType, responseRecorder.Header().Get("Content-Type"))
+
+			var problem Problem
+			require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &problem))
+			require.Equal(t, tc.problemType, problem.Type)
+			require.Equal(t, http.StatusText(tc.status), problem.Title)
+			require.Equal(t, tc.status, problem.Status)
+			require.NotEmpty(t, problem.Instance)
+		})
+	}
+}
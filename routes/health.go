@@ -0,0 +1,79 @@
+package routes
+
+import (
+	"context"
+	"go-twitter-test/container"
+	"go-twitter-test/sqlite"
+	"go-twitter-test/version"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+)
+
+// pingTimeout is how long the readiness probe waits for the database
+const pingTimeout = 2 * time.Second
+
+type probesRouter struct {
+	container container.Container
+}
+
+type healthResponse struct {
+	Status string `json:"status"`
+	// SchemaVersion is set by the readiness probe only
+	SchemaVersion int `json:"schema_version,omitempty"`
+}
+
+type versionResponse struct {
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// Healthz tells whether the process is alive, it doesn't check any dependency
+func (pr *probesRouter) Healthz(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, &healthResponse{Status: "ok"})
+}
+
+// Readyz tells whether the API can serve requests: the database must be reachable and all the migrations known to
+// the binary must have been applied, a 503 is returned otherwise. A newer schema is fine since migrations are
+// backward compatible, so that the new version of the API can migrate before the old one is replaced.
+func (pr *probesRouter) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), pingTimeout)
+	defer cancel()
+
+	if err := pr.container.Ping(ctx); err != nil {
+		pr.container.Logger().Error("Readiness probe could not ping db", "error", err)
+		RenderError(w, r, "Database is unreachable", http.StatusServiceUnavailable)
+		return
+	}
+
+	schemaVersion, err := pr.container.SchemaVersion(ctx)
+	if err != nil {
+		pr.container.Logger().Error("Readiness probe could not get schema version", "error", err)
+		RenderError(w, r, "Database is unreachable", http.StatusServiceUnavailable)
+		return
+	}
+
+	if expected := sqlite.LatestVersion(); schemaVersion < expected {
+		RenderError(
+			w, r,
+			"Database schema is at version "+strconv.Itoa(schemaVersion)+", expected at least "+strconv.Itoa(expected),
+			http.StatusServiceUnavailable,
+		)
+		return
+	}
+
+	render.JSON(w, r, &healthResponse{Status: "ready", SchemaVersion: schemaVersion})
+}
+
+// Version returns the build information of the binary
+func (pr *probesRouter) Version(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, &versionResponse{
+		Commit:    version.Commit,
+		BuildTime: version.BuildTime,
+		GoVersion: runtime.Version(),
+	})
+}
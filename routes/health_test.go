@@ -0,0 +1,120 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"go-twitter-test/container/mock"
+	"go-twitter-test/repositories/messages/messagesfakes"
+	"go-twitter-test/sqlite"
+	"go-twitter-test/version"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbes_Healthz(t *testing.T) {
+	router := NewRouter(mock.NewMockedContainer())
+
+	// no Content-Type nor X-User-ID, the API middlewares must be bypassed
+	request, err := http.NewRequest("GET", "/healthz", nil)
+	require.Nil(t, err)
+
+	responseRecorder := httptest.NewRecorder()
+	router.ServeHTTP(responseRecorder, request)
+
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+	require.JSONEq(t, `{"status":"ok"}`, responseRecorder.Body.String())
+}
+
+func TestProbes_Readyz(t *testing.T) {
+	for name, tc := range map[string]struct {
+		pingErr       error
+		schemaVersion int
+		versionErr    error
+		status        int
+	}{
+		"ready":              {nil, sqlite.LatestVersion(), nil, http.StatusOK},
+		"db unreachable":     {errors.New("some error"), sqlite.LatestVersion(), nil, http.StatusServiceUnavailable},
+		"version error":      {nil, 0, errors.New("some error"), http.StatusServiceUnavailable},
+		"pending migrations": {nil, sqlite.LatestVersion() - 1, nil, http.StatusServiceUnavailable},
+		// during a rolling deploy the new version migrates while the old one is still serving
+		"newer schema": {nil, sqlite.LatestVersion() + 1, nil, http.StatusOK},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := mock.NewMockedContainer()
+			c.PingReturns(tc.pingErr)
+			c.SchemaVersionReturns(tc.schemaVersion, tc.versionErr)
+			router := NewRouter(c)
+
+			request, err := http.NewRequest("GET", "/readyz", nil)
+			require.Nil(t, err)
+
+			responseRecorder := httptest.NewRecorder()
+			router.ServeHTTP(responseRecorder, request)
+
+			require.Equal(t, tc.status, responseRecorder.Code)
+			require.Equal(t, 1, c.PingCallCount())
+			if tc.pingErr == nil {
+				ctx := c.SchemaVersionArgsForCall(0)
+				_, hasDeadline := ctx.Deadline()
+				require.True(t, hasDeadline, "the schema version must be read within the probe deadline")
+			}
+			if tc.status != http.StatusOK {
+				require.Equal(t, problemContentType, responseRecorder.Header().Get("Content-Type"))
+				return
+			}
+
+			var response healthResponse
+			require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &response))
+			require.Equal(t, healthResponse{Status: "ready", SchemaVersion: tc.schemaVersion}, response)
+		})
+	}
+}
+
+func TestProbes_Version(t *testing.T) {
+	router := NewRouter(mock.NewMockedContainer())
+
+	request, err := http.NewRequest("GET", "/version", nil)
+	require.Nil(t, err)
+
+	responseRecorder := httptest.NewRecorder()
+	router.ServeHTTP(responseRecorder, request)
+
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+
+	var response versionResponse
+	require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &response))
+	require.Equal(t, version.Commit, response.Commit)
+	require.Equal(t, version.BuildTime, response.BuildTime)
+	require.NotEmpty(t, response.GoVersion)
+}
+
+func TestMetrics(t *testing.T) {
+	c := mock.NewMockedContainer()
+	messagesRepo := &messagesfakes.FakeRepository{}
+	messagesRepo.GetReturns(nil, sql.ErrNoRows)
+	c.MessagesRepositoryReturns(messagesRepo)
+	router := NewRouter(c)
+
+	for _, url := range []string{"/v1/messages/1", "/v1/messages/2", "/v1/unknown/1"} {
+		request, err := http.NewRequest("GET", url, nil)
+		require.Nil(t, err)
+		router.ServeHTTP(httptest.NewRecorder(), request)
+	}
+
+	request, err := http.NewRequest("GET", "/metrics", nil)
+	require.Nil(t, err)
+
+	responseRecorder := httptest.NewRecorder()
+	router.ServeHTTP(responseRecorder, request)
+
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+	// the requests are grouped by route pattern, not by path
+	require.Contains(t, responseRecorder.Body.String(),
+		`twitter_http_requests_total{method="GET",route="/v1/messages/{id:[0-9]+}",status="404"} 2`,
+	)
+	require.NotContains(t, responseRecorder.Body.String(), "/v1/unknown")
+}
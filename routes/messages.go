@@ -2,15 +2,15 @@ package routes
 
 import (
 	"database/sql"
-	"encoding/json"
+	"go-twitter-test/config"
+	"go-twitter-test/pagination"
+	"go-twitter-test/parser"
 	"go-twitter-test/repositories/messages"
 	"go-twitter-test/repositories/tags"
 	"go-twitter-test/repositories/users"
-	"io/ioutil"
-	"log"
 	"net/http"
-	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
@@ -22,18 +22,35 @@ func NewMessagesRouter(
 	messagesRepository messages.Repository,
 	usersRepository users.Repository,
 	tagsRepository tags.Repository,
-	logger *log.Logger,
+	cursorCodec *pagination.Codec,
+	features config.Features,
 ) *chi.Mux {
 	router := chi.NewRouter()
 	msgs := &messagesRouter{
 		messagesRepository: messagesRepository,
 		usersRepository:    usersRepository,
 		tagsRepository:     tagsRepository,
-		logger:             logger,
+		cursorCodec:        cursorCodec,
+		features:           features,
 	}
 
 	router.Get("/", msgs.GetMessages)
 	router.Post("/", msgs.CreateMessage)
+	router.Get("/{id:[0-9]+}", msgs.GetMessage)
+	router.Delete("/{id:[0-9]+}", msgs.DeleteMessage)
+	router.Get("/{id:[0-9]+}/thread", msgs.GetThread)
+	if features.Edits {
+		router.Patch("/{id:[0-9]+}", msgs.UpdateMessage)
+		router.Get("/{id:[0-9]+}/history", msgs.GetHistory)
+	}
+	if features.Reposts {
+		router.Post("/{id:[0-9]+}/repost", msgs.Repost)
+		router.Delete("/{id:[0-9]+}/repost", msgs.Unrepost)
+	}
+	if features.Likes {
+		router.Put("/{id:[0-9]+}/like", msgs.Like)
+		router.Delete("/{id:[0-9]+}/like", msgs.Unlike)
+	}
 
 	return router
 }
@@ -42,89 +59,192 @@ type messagesRouter struct {
 	messagesRepository messages.Repository
 	usersRepository    users.Repository
 	tagsRepository     tags.Repository
-	logger             *log.Logger
+	cursorCodec        *pagination.Codec
+	features           config.Features
 }
 
 func (mr *messagesRouter) GetMessages(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 
-	var err error
-	var tagID int64
-	if tag := query.Get("tag"); tag != "" {
-		if tagID, err = mr.tagsRepository.GetID(tag); err != nil {
-			if err == sql.ErrNoRows {
-				RenderError(w, r, "Tag not found", http.StatusNotFound)
-			} else {
-				RenderError(w, r, "Could not get tag ID", http.StatusInternalServerError)
-				mr.logger.Printf("Could not get tag ID: %v", err)
-			}
-
-			return
-		}
-	}
-
-	dateStart := query.Get("dateStart")
-	dateEnd := query.Get("dateEnd")
-	if dateStart != "" || dateEnd != "" {
-		if dateStart == "" || dateEnd == "" {
-			RenderError(w, r, "dateStart and dateEnd must be used together or not at all", http.StatusBadRequest)
-			return
-		}
+	filter, _, ok := mr.parseFilter(w, r)
+	if !ok {
+		return
 	}
 
-	var unixStart, unixEnd int64
-	if dateStart != "" && dateEnd != "" {
-		re := regexp.MustCompile("^[0-9]{4}-[0-9]{2}-[0-9]{2}$")
-		if !re.MatchString(dateStart) {
-			RenderError(w, r, "Invalid date start YYYY-MM-DD", http.StatusBadRequest)
+	var responseBody interface{}
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		if !mr.features.Search {
+			RenderError(w, r, "Full-text search is disabled", http.StatusNotImplemented)
 			return
 		}
-		if !re.MatchString(dateEnd) {
-			RenderError(w, r, "Invalid date end YYYY-MM-DD", http.StatusBadRequest)
+		if query.Get("count") == "1" || query.Get("cursor") != "" {
+			RenderError(w, r, "q can't be used along with count or cursor", http.StatusBadRequest)
 			return
 		}
 
-		layout := "2006-01-02T15:04:05.000Z"
-		timeStart, err := time.Parse(layout, dateStart+"T00:00:00.000Z")
+		p, err := parsePage(r, mr.cursorCodec)
 		if err != nil {
-			RenderError(w, r, "Cannot parse date start YYYY-MM-DD", http.StatusBadRequest)
+			RenderError(w, r, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		timeEnd, err := time.Parse(layout, dateEnd+"T23:59:59.000Z")
+		results, err := mr.messagesRepository.Search(r.Context(), q, filter, p.Limit)
 		if err != nil {
-			RenderError(w, r, "Cannot parse date end YYYY-MM-DD", http.StatusBadRequest)
+			if err == messages.ErrSearchUnavailable {
+				RenderError(w, r, "Full-text search is not available", http.StatusNotImplemented)
+			} else {
+				RenderError(w, r, "Could not search messages", http.StatusInternalServerError)
+				requestLogger(r).Error("Could not search messages", "query_length", len(q), "error", err)
+			}
+
 			return
 		}
 
-		unixStart = timeStart.Unix()
-		unixEnd = timeEnd.Unix()
-	}
-
-	var responseBody interface{}
-	if query.Get("count") == "1" {
-		count, err := mr.messagesRepository.CountMessages(tagID, unixStart, unixEnd)
+		// search results are sorted by relevance so they can't be browsed with cursors, only the limit applies
+		responseBody = &page{Data: results}
+	} else if query.Get("count") == "1" {
+		count, err := mr.messagesRepository.CountMessages(r.Context(), filter)
 		if err != nil {
 			RenderError(w, r, "Could not count messages", http.StatusInternalServerError)
-			mr.logger.Printf("Could not count messages: %v", err)
+			requestLogger(r).Error("Could not count messages", "error", err)
 			return
 		}
 
 		responseBody = count
 	} else {
-		list, err := mr.messagesRepository.GetMessages(tagID, unixStart, unixEnd)
+		p, err := parsePage(r, mr.cursorCodec)
+		if err != nil {
+			RenderError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		list, err := mr.messagesRepository.GetMessages(r.Context(), filter, p)
 		if err != nil {
 			RenderError(w, r, "Could not get messages", http.StatusInternalServerError)
-			mr.logger.Printf("Could not get messages: %v", err)
+			requestLogger(r).Error("Could not get messages", "error", err)
 			return
 		}
 
-		responseBody = list
+		responseBody = newPage(r, mr.cursorCodec, list.Messages, p.Limit, list.Next, list.Prev)
 	}
 
 	render.JSON(w, r, responseBody)
 }
 
+// parseFilter reads the tag, dateStart, dateEnd and tz query parameters and returns the filter along with the time
+// zone, the dates are interpreted in tz (UTC by default) while the date-times carry their own offset.
+// If they're not valid an error is rendered and false is returned.
+func (mr *messagesRouter) parseFilter(
+	w http.ResponseWriter, r *http.Request,
+) (messages.Filter, *time.Location, bool) {
+	query := r.URL.Query()
+
+	var err error
+	filter := messages.Filter{ViewerID: r.Context().Value(userIDKey).(int64)}
+	if tag := query.Get("tag"); tag != "" {
+		if filter.TagID, err = mr.tagsRepository.GetID(r.Context(), tag); err != nil {
+			if err == sql.ErrNoRows {
+				RenderError(w, r, "Tag not found", http.StatusNotFound)
+			} else {
+				RenderError(w, r, "Could not get tag ID", http.StatusInternalServerError)
+				requestLogger(r).Error("Could not get tag ID", "tag", tag, "error", err)
+			}
+
+			return filter, nil, false
+		}
+	}
+
+	loc := time.UTC
+	if tz := query.Get("tz"); tz != "" {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			RenderError(w, r, "Invalid time zone, expected an IANA name (e.g. Europe/Rome)", http.StatusBadRequest)
+			return filter, nil, false
+		}
+	}
+
+	if dateStart := query.Get("dateStart"); dateStart != "" {
+		if filter.DateStart, err = parseTime(dateStart, loc, false); err != nil {
+			RenderError(w, r, "Invalid date start, expected YYYY-MM-DD or an RFC 3339 date-time", http.StatusBadRequest)
+			return filter, nil, false
+		}
+	}
+	if dateEnd := query.Get("dateEnd"); dateEnd != "" {
+		if filter.DateEnd, err = parseTime(dateEnd, loc, true); err != nil {
+			RenderError(w, r, "Invalid date end, expected YYYY-MM-DD or an RFC 3339 date-time", http.StatusBadRequest)
+			return filter, nil, false
+		}
+	}
+	if !filter.DateStart.IsZero() && !filter.DateEnd.IsZero() && !filter.DateStart.Before(filter.DateEnd) {
+		RenderError(w, r, "dateStart must be before dateEnd", http.StatusBadRequest)
+		return filter, nil, false
+	}
+
+	return filter, loc, true
+}
+
+// parseTime parses either an RFC 3339 date-time or a date (YYYY-MM-DD) in loc, which is the midnight starting the
+// day or, when end is true, the midnight ending it so that the whole day is included in a range ending there
+func parseTime(value string, loc *time.Location, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+
+	day, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		day = day.AddDate(0, 0, 1)
+	}
+
+	return day, nil
+}
+
+func (mr *messagesRouter) GetMessage(w http.ResponseWriter, r *http.Request) {
+	msgID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		RenderError(w, r, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	msg, err := mr.messagesRepository.Get(r.Context(), msgID, r.Context().Value(userIDKey).(int64))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			RenderError(w, r, "Message not found", http.StatusNotFound)
+		} else {
+			RenderError(w, r, "Could not get message", http.StatusInternalServerError)
+			requestLogger(r).Error("Could not get message", "message_id", msgID, "error", err)
+		}
+
+		return
+	}
+
+	render.JSON(w, r, msg)
+}
+
+// GetThread returns the whole conversation the message belongs to, ordered for display
+func (mr *messagesRouter) GetThread(w http.ResponseWriter, r *http.Request) {
+	msgID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		RenderError(w, r, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	thread, err := mr.messagesRepository.GetThread(r.Context(), msgID, r.Context().Value(userIDKey).(int64))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			RenderError(w, r, "Message not found", http.StatusNotFound)
+		} else {
+			RenderError(w, r, "Could not get thread", http.StatusInternalServerError)
+			requestLogger(r).Error("Could not get thread", "message_id", msgID, "error", err)
+		}
+
+		return
+	}
+
+	render.JSON(w, r, thread)
+}
+
 func (mr *messagesRouter) CreateMessage(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(userIDKey).(int64)
 	if userID == 0 {
@@ -134,7 +254,7 @@ func (mr *messagesRouter) CreateMessage(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user, err := mr.usersRepository.Get(userID)
+	user, err := mr.usersRepository.Get(r.Context(), userID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			// if this happens there's most likely an issue with the API Gateway, we should log and
@@ -142,41 +262,60 @@ func (mr *messagesRouter) CreateMessage(w http.ResponseWriter, r *http.Request)
 			RenderError(w, r, "User not found", http.StatusForbidden)
 		} else {
 			RenderError(w, r, "Repository error", http.StatusInternalServerError)
-			mr.logger.Printf("Could not get user %q: %v", userID, err)
+			requestLogger(r).Error("Could not get user", "error", err)
 		}
 
 		return
 	}
 
-	jsonData, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		RenderError(w, r, "Invalid request body", http.StatusBadRequest)
+	var body message
+	if !decodeBody(w, r, &body, "Request body is not a valid message") {
 		return
 	}
 
-	var body message
-	if err := json.Unmarshal(jsonData, &body); err != nil {
-		RenderError(w, r, "Request body is not a valid message", http.StatusBadRequest)
+	if errs := validateMessage(body.Text, body.Tags, body.Tag); errs != nil {
+		RenderValidationError(w, r, "Request body is not a valid message", errs)
 		return
 	}
 
-	tagID, err := mr.tagsRepository.Put(body.Tag)
+	// hashtags found in the text are merged with the explicit tags, duplicates are taken care of by PutMany
+	entities := parser.Parse(body.Text)
+	tags := append(body.Tags, parser.Hashtags(entities)...)
+	if body.Tag != nil {
+		tags = append(tags, *body.Tag)
+	}
+
+	tagIDs, err := mr.tagsRepository.PutMany(r.Context(), tags)
 	if err != nil {
-		RenderError(w, r, "Could not create tag", http.StatusInternalServerError)
-		mr.logger.Printf("Could not put tag %q: %v", body.Tag, err)
+		RenderError(w, r, "Could not create tags", http.StatusInternalServerError)
+		requestLogger(r).Error("Could not put tags", "tags", tags, "error", err)
 		return
 	}
 
 	msg := messages.MessageCreate{
-		TagID:   tagID,
-		UserID:  user.ID,
-		Message: body.Text,
+		TagIDs:    tagIDs,
+		Mentions:  parser.Mentions(entities),
+		UserID:    user.ID,
+		Message:   body.Text,
+		InReplyTo: body.InReplyTo,
+		QuoteOf:   body.QuoteOf,
 	}
 
-	msgID, err := mr.messagesRepository.Create(msg)
+	msgID, err := mr.messagesRepository.Create(r.Context(), msg)
 	if err != nil {
-		RenderError(w, r, "Could not create message", http.StatusInternalServerError)
-		mr.logger.Printf("Could not create message %+v: %v", msg, err)
+		if err == messages.ErrParentNotFound {
+			RenderError(w, r, "The message being replied to does not exist", http.StatusBadRequest)
+		} else if err == messages.ErrQuotedNotFound {
+			RenderError(w, r, "The message being quoted does not exist", http.StatusBadRequest)
+		} else {
+			RenderError(w, r, "Could not create message", http.StatusInternalServerError)
+			// the text isn't logged, only its length along with the IDs
+			requestLogger(r).Error(
+				"Could not create message",
+				"in_reply_to", msg.InReplyTo, "quote_of", msg.QuoteOf, "message_length", len(msg.Message), "error", err,
+			)
+		}
+
 		return
 	}
 
@@ -185,7 +324,256 @@ func (mr *messagesRouter) CreateMessage(w http.ResponseWriter, r *http.Request)
 	render.JSON(w, r, nil)
 }
 
+// UpdateMessage edits the text of a message written by the caller, the tags are replaced by the given ones plus
+// the hashtags found in the new text. The updated message is returned.
+func (mr *messagesRouter) UpdateMessage(w http.ResponseWriter, r *http.Request) {
+	msgID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		RenderError(w, r, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Context().Value(userIDKey).(int64)
+	if userID == 0 {
+		RenderError(w, r, "No user ID provided", http.StatusUnauthorized)
+		return
+	}
+
+	var body messageUpdate
+	if !decodeBody(w, r, &body, "Request body is not a valid message") {
+		return
+	}
+
+	if errs := validateMessage(body.Text, body.Tags, nil); errs != nil {
+		RenderValidationError(w, r, "Request body is not a valid message", errs)
+		return
+	}
+
+	// the tags are only created once it's known that the caller can edit the message, Update checks it again
+	if err := mr.messagesRepository.CheckEditable(r.Context(), userID, msgID); err != nil {
+		renderUpdateError(w, r, msgID, err)
+		return
+	}
+
+	entities := parser.Parse(body.Text)
+	tags := append(body.Tags, parser.Hashtags(entities)...)
+	tagIDs, err := mr.tagsRepository.PutMany(r.Context(), tags)
+	if err != nil {
+		RenderError(w, r, "Could not create tags", http.StatusInternalServerError)
+		requestLogger(r).Error("Could not put tags", "tags", tags, "error", err)
+		return
+	}
+
+	msg := messages.MessageUpdate{
+		ID:       msgID,
+		UserID:   userID,
+		TagIDs:   tagIDs,
+		Mentions: parser.Mentions(entities),
+		Message:  body.Text,
+	}
+
+	if err := mr.messagesRepository.Update(r.Context(), msg); err != nil {
+		renderUpdateError(w, r, msgID, err)
+		return
+	}
+
+	updated, err := mr.messagesRepository.Get(r.Context(), msgID, userID)
+	if err != nil {
+		RenderError(w, r, "Could not get message", http.StatusInternalServerError)
+		requestLogger(r).Error("Could not get message", "message_id", msgID, "error", err)
+		return
+	}
+
+	render.JSON(w, r, updated)
+}
+
+// renderUpdateError renders the errors returned by CheckEditable and Update
+func renderUpdateError(w http.ResponseWriter, r *http.Request, msgID int64, err error) {
+	switch err {
+	case sql.ErrNoRows:
+		RenderError(w, r, "Message not found", http.StatusNotFound)
+	case messages.ErrNotAuthor:
+		RenderError(w, r, "Users can only edit their own messages", http.StatusForbidden)
+	case messages.ErrNotEditable:
+		RenderError(w, r, "Reposts and messages older than the edit window can't be edited", http.StatusConflict)
+	default:
+		RenderError(w, r, "Could not update message", http.StatusInternalServerError)
+		requestLogger(r).Error("Could not update message", "message_id", msgID, "error", err)
+	}
+}
+
+// DeleteMessage deletes a message written by the caller
+func (mr *messagesRouter) DeleteMessage(w http.ResponseWriter, r *http.Request) {
+	msgID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		RenderError(w, r, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Context().Value(userIDKey).(int64)
+	if userID == 0 {
+		RenderError(w, r, "No user ID provided", http.StatusUnauthorized)
+		return
+	}
+
+	if err := mr.messagesRepository.Delete(r.Context(), userID, msgID); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RenderError(w, r, "Message not found", http.StatusNotFound)
+		case messages.ErrNotAuthor:
+			RenderError(w, r, "Users can only delete their own messages", http.StatusForbidden)
+		default:
+			RenderError(w, r, "Could not delete message", http.StatusInternalServerError)
+			requestLogger(r).Error("Could not delete message", "message_id", msgID, "error", err)
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetHistory returns all the versions of the text of a message, the current one last
+func (mr *messagesRouter) GetHistory(w http.ResponseWriter, r *http.Request) {
+	msgID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		RenderError(w, r, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	history, err := mr.messagesRepository.GetHistory(r.Context(), msgID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			RenderError(w, r, "Message not found", http.StatusNotFound)
+		} else {
+			RenderError(w, r, "Could not get message history", http.StatusInternalServerError)
+			requestLogger(r).Error("Could not get history", "message_id", msgID, "error", err)
+		}
+
+		return
+	}
+
+	render.JSON(w, r, history)
+}
+
+// Repost shares the message on behalf of the caller, reposting a message twice returns the existing repost
+func (mr *messagesRouter) Repost(w http.ResponseWriter, r *http.Request) {
+	msgID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		RenderError(w, r, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Context().Value(userIDKey).(int64)
+	if userID == 0 {
+		RenderError(w, r, "No user ID provided", http.StatusUnauthorized)
+		return
+	}
+
+	if !callerExists(w, r, mr.usersRepository, userID) {
+		return
+	}
+
+	repostID, err := mr.messagesRepository.Repost(r.Context(), userID, msgID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			RenderError(w, r, "Message not found", http.StatusNotFound)
+		} else {
+			RenderError(w, r, "Could not repost message", http.StatusInternalServerError)
+			requestLogger(r).Error("Could not repost message", "message_id", msgID, "error", err)
+		}
+
+		return
+	}
+
+	w.Header().Set("Location", "/v1/messages/"+strconv.FormatInt(repostID, 10))
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, nil)
+}
+
+// Unrepost undoes the caller's repost of the message, undoing a repost that does not exist is not an error
+func (mr *messagesRouter) Unrepost(w http.ResponseWriter, r *http.Request) {
+	msgID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		RenderError(w, r, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Context().Value(userIDKey).(int64)
+	if userID == 0 {
+		RenderError(w, r, "No user ID provided", http.StatusUnauthorized)
+		return
+	}
+
+	if !callerExists(w, r, mr.usersRepository, userID) {
+		return
+	}
+
+	if err := mr.messagesRepository.Unrepost(r.Context(), userID, msgID); err != nil {
+		RenderError(w, r, "Could not undo repost", http.StatusInternalServerError)
+		requestLogger(r).Error("Could not undo repost", "message_id", msgID, "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Like makes the caller like the message, liking a message twice is not an error
+func (mr *messagesRouter) Like(w http.ResponseWriter, r *http.Request) {
+	mr.like(w, r, true)
+}
+
+// Unlike makes the caller stop liking the message, unliking a message that is not liked is not an error
+func (mr *messagesRouter) Unlike(w http.ResponseWriter, r *http.Request) {
+	mr.like(w, r, false)
+}
+
+func (mr *messagesRouter) like(w http.ResponseWriter, r *http.Request, like bool) {
+	msgID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		RenderError(w, r, "Invalid message ID", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Context().Value(userIDKey).(int64)
+	if userID == 0 {
+		RenderError(w, r, "No user ID provided", http.StatusUnauthorized)
+		return
+	}
+
+	if !callerExists(w, r, mr.usersRepository, userID) {
+		return
+	}
+
+	if like {
+		err = mr.messagesRepository.Like(r.Context(), userID, msgID)
+	} else {
+		err = mr.messagesRepository.Unlike(r.Context(), userID, msgID)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			RenderError(w, r, "Message not found", http.StatusNotFound)
+		} else {
+			RenderError(w, r, "Could not update like", http.StatusInternalServerError)
+			requestLogger(r).Error("Could not update like", "message_id", msgID, "like", like, "error", err)
+		}
+
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 type message struct {
-	Text string `json:"text"`
-	Tag  string `json:"tag"`
+	Text string   `json:"text"`
+	Tags []string `json:"tags"`
+	// Tag is deprecated, kept for clients that still send a single tag
+	Tag       *string `json:"tag,omitempty"`
+	InReplyTo int64   `json:"in_reply_to,omitempty"`
+	QuoteOf   int64   `json:"quote_of,omitempty"`
+}
+
+type messageUpdate struct {
+	Text string   `json:"text"`
+	Tags []string `json:"tags"`
 }
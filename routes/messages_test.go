@@ -2,8 +2,13 @@ package routes
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"go-twitter-test/config"
 	"go-twitter-test/container/mock"
+	"go-twitter-test/logging"
+	"go-twitter-test/pagination"
 	"go-twitter-test/repositories/messages"
 	"go-twitter-test/repositories/messages/messagesfakes"
 	"go-twitter-test/repositories/tags/tagsfakes"
@@ -12,7 +17,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -28,18 +35,18 @@ func TestMessagesRouter_CreateMessage(t *testing.T) {
 	c.MessagesRepositoryReturns(messagesRepo)
 
 	// Setting up mocks
-	const mockedTagID int64 = 123
+	mockedTagIDs := []int64{123, 124}
 	const mockedUserID int64 = 456
 	const mockedMessageID int64 = 789
 	usersRepo.GetReturns(&users.User{ID: mockedUserID, Email: "user@email.com"}, nil)
-	tagsRepo.PutReturns(mockedTagID, nil)
+	tagsRepo.PutManyReturns(mockedTagIDs, nil)
 	messagesRepo.CreateReturns(mockedMessageID, nil)
 
 	// Setting up router and HTTP request
 	router := NewRouter(c)
 	request, err := http.NewRequest("POST", "/v1/messages", getRequestBody(t, message{
-		Text: "A short message",
-		Tag:  "my-test",
+		Text: "A short #message for @Alice",
+		Tags: []string{"my-test", "another-test"},
 	}))
 	require.Nil(t, err)
 
@@ -50,14 +57,18 @@ func TestMessagesRouter_CreateMessage(t *testing.T) {
 
 	// Assertions
 	require.Equal(t, http.StatusCreated, responseRecorder.Code)
-	require.Equal(t, mockedUserID, usersRepo.GetArgsForCall(0))
-	require.Equal(t, "my-test", tagsRepo.PutArgsForCall(0))
+	_, userID := usersRepo.GetArgsForCall(0)
+	require.Equal(t, mockedUserID, userID)
+	_, tagList := tagsRepo.PutManyArgsForCall(0)
+	require.Equal(t, []string{"my-test", "another-test", "message"}, tagList)
+	_, created := messagesRepo.CreateArgsForCall(0)
 	require.EqualValues(t, messages.MessageCreate{
-		ID:      0,
-		UserID:  mockedUserID,
-		TagID:   mockedTagID,
-		Message: "A short message",
-	}, messagesRepo.CreateArgsForCall(0))
+		ID:       0,
+		UserID:   mockedUserID,
+		TagIDs:   mockedTagIDs,
+		Mentions: []string{"alice"},
+		Message:  "A short #message for @Alice",
+	}, created)
 	require.Equal(
 		t,
 		"/v1/messages/"+strconv.FormatInt(mockedMessageID, 10),
@@ -65,6 +76,26 @@ func TestMessagesRouter_CreateMessage(t *testing.T) {
 	)
 }
 
+func TestMessagesRouter_CreateMessage_ErrorLog(t *testing.T) {
+	var buf bytes.Buffer
+	c := mock.NewMockedContainer()
+	c.LoggerReturns(logging.New(&buf, "info"))
+	usersRepo := &usersfakes.FakeRepository{}
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.TagsRepositoryReturns(&tagsfakes.FakeRepository{})
+	c.UsersRepositoryReturns(usersRepo)
+	c.MessagesRepositoryReturns(messagesRepo)
+	usersRepo.GetReturns(&users.User{ID: 7}, nil)
+	messagesRepo.CreateReturns(0, errors.New("could not create message for user ID 7: database is locked"))
+
+	responseRecorder := doJSONRequest(t, NewRouter(c), "POST", "/v1/messages", 7, message{Text: "A private thought"})
+	require.Equal(t, http.StatusInternalServerError, responseRecorder.Code)
+
+	// only the length of the text is logged
+	require.Contains(t, buf.String(), `"message_length":17`)
+	require.NotContains(t, buf.String(), "private")
+}
+
 func TestMessagesRouter_CreateMessage_UserHeaderMissing(t *testing.T) {
 	t.Skip("@TODO implement")
 }
@@ -74,7 +105,60 @@ func TestMessagesRouter_CreateMessage_UserNotFound(t *testing.T) {
 }
 
 func TestMessagesRouter_CreateMessage_RequestBodyInvalid(t *testing.T) {
-	t.Skip("@TODO implement")
+	c := mock.NewMockedContainer()
+	usersRepo := &usersfakes.FakeRepository{}
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.TagsRepositoryReturns(&tagsfakes.FakeRepository{})
+	c.UsersRepositoryReturns(usersRepo)
+	c.MessagesRepositoryReturns(messagesRepo)
+	usersRepo.GetReturns(&users.User{ID: 7}, nil)
+
+	emptyTag := ""
+	longText := strings.Repeat("👍🏽", maxMessageLength) // many runes but a single grapheme each
+	for name, tc := range map[string]struct {
+		body   message
+		errors []FieldError
+	}{
+		"empty text": {
+			body:   message{Text: "  "},
+			errors: []FieldError{{Field: "text", Message: "Text is required"}},
+		},
+		"text too long": {
+			body:   message{Text: longText + "!"},
+			errors: []FieldError{{Field: "text", Message: "Text must be at most 280 characters long"}},
+		},
+		"invalid tags": {
+			body: message{
+				Text: "Hello #" + strings.Repeat("a", 51),
+				Tags: []string{"ok", "not ok!"},
+				Tag:  &emptyTag,
+			},
+			errors: []FieldError{
+				{Field: "text", Message: "Hashtag #" + strings.Repeat("a", 51) + " is not a valid tag"},
+				{Field: "tags[1]", Message: invalidTagMessage},
+				{Field: "tag", Message: invalidTagMessage},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			responseRecorder := doJSONRequest(t, NewRouter(c), "POST", "/v1/messages", 7, tc.body)
+			require.Equal(t, http.StatusBadRequest, responseRecorder.Code)
+
+			var body Problem
+			require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &body))
+			require.Equal(t, validationProblemType, body.Type)
+			require.Equal(t, tc.errors, body.Errors)
+		})
+	}
+
+	responseRecorder := doJSONRequest(t, NewRouter(c), "POST", "/v1/messages", 7, message{Text: longText})
+	require.Equal(t, http.StatusCreated, responseRecorder.Code)
+
+	responseRecorder = doJSONRequest(t, NewRouter(c), "POST", "/v1/messages", 7, message{
+		Text: strings.Repeat("a", maxBodySize),
+	})
+	require.Equal(t, http.StatusRequestEntityTooLarge, responseRecorder.Code)
+	require.Equal(t, 1, messagesRepo.CreateCallCount())
 }
 
 func TestMessagesRouter_CreateMessage_TagsRepositoryFailure(t *testing.T) {
@@ -89,8 +173,153 @@ func TestMessagesRouter_GetMessages(t *testing.T) {
 	t.Skip("@TODO implement")
 }
 
+func TestMessagesRouter_GetMessages_Pagination(t *testing.T) {
+	c := mock.NewMockedContainer()
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.MessagesRepositoryReturns(messagesRepo)
+
+	codec := c.CursorCodec()
+	next := &pagination.Cursor{CreatedAt: 1567296000, ID: 2}
+	messagesRepo.GetMessagesReturns(&messages.MessagePage{
+		Messages: []messages.MessageList{{ID: 1}, {ID: 2}},
+		Next:     next,
+	}, nil)
+
+	router := NewRouter(c)
+	cursor := pagination.Cursor{CreatedAt: 1567295000, ID: 7, Backward: true}
+	request, err := http.NewRequest("GET", "/v1/messages?limit=2&cursor="+codec.Encode(cursor), nil)
+	require.Nil(t, err)
+
+	responseRecorder := httptest.NewRecorder()
+	router.ServeHTTP(responseRecorder, request)
+
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+
+	_, _, p := messagesRepo.GetMessagesArgsForCall(0)
+	require.Equal(t, pagination.Page{Limit: 2, Cursor: &cursor}, p)
+
+	var body struct {
+		Data []messages.MessageList `json:"data"`
+		Next string                 `json:"next"`
+		Prev string                 `json:"prev"`
+	}
+	require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &body))
+	require.Len(t, body.Data, 2)
+	require.Equal(t, "/v1/messages?cursor="+codec.Encode(*next)+"&limit=2", body.Next)
+	require.Empty(t, body.Prev)
+}
+
+func TestMessagesRouter_GetMessages_Search(t *testing.T) {
+	c := mock.NewMockedContainer()
+	messagesRepo := &messagesfakes.FakeRepository{}
+	tagsRepo := &tagsfakes.FakeRepository{}
+	c.MessagesRepositoryReturns(messagesRepo)
+	c.TagsRepositoryReturns(tagsRepo)
+
+	tagsRepo.GetIDReturns(5, nil)
+	messagesRepo.SearchReturns([]messages.SearchResult{{
+		MessageList: messages.MessageList{ID: 1, Message: "Gophers love SQLite"},
+		Snippet:     "Gophers love <mark>SQLite</mark>",
+	}}, nil)
+
+	router := NewRouter(c)
+	request, err := http.NewRequest("GET", "/v1/messages?q=sqlite&tag=go&limit=5", nil)
+	require.Nil(t, err)
+
+	responseRecorder := httptest.NewRecorder()
+	router.ServeHTTP(responseRecorder, request)
+
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+
+	_, q, filter, limit := messagesRepo.SearchArgsForCall(0)
+	require.Equal(t, "sqlite", q)
+	require.Equal(t, messages.Filter{TagID: 5}, filter)
+	require.Equal(t, 5, limit)
+
+	var body struct {
+		Data []messages.SearchResult `json:"data"`
+	}
+	require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &body))
+	require.Len(t, body.Data, 1)
+	require.Equal(t, "Gophers love <mark>SQLite</mark>", body.Data[0].Snippet)
+}
+
+func TestMessagesRouter_GetMessages_SearchUnavailable(t *testing.T) {
+	c := mock.NewMockedContainer()
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.MessagesRepositoryReturns(messagesRepo)
+	messagesRepo.SearchReturns(nil, messages.ErrSearchUnavailable)
+
+	router := NewRouter(c)
+	request, err := http.NewRequest("GET", "/v1/messages?q=sqlite", nil)
+	require.Nil(t, err)
+
+	responseRecorder := httptest.NewRecorder()
+	router.ServeHTTP(responseRecorder, request)
+
+	require.Equal(t, http.StatusNotImplemented, responseRecorder.Code)
+}
+
+func TestMessagesRouter_GetMessages_InvalidPagination(t *testing.T) {
+	c := mock.NewMockedContainer()
+	router := NewRouter(c)
+
+	for _, query := range []string{"limit=0", "limit=1000", "limit=abc", "cursor=forged"} {
+		request, err := http.NewRequest("GET", "/v1/messages?"+query, nil)
+		require.Nil(t, err)
+
+		responseRecorder := httptest.NewRecorder()
+		router.ServeHTTP(responseRecorder, request)
+
+		require.Equal(t, http.StatusBadRequest, responseRecorder.Code, query)
+	}
+}
+
 func TestMessagesRouter_GetMessages_FilterByTagAndDateRange(t *testing.T) {
-	t.Skip("@TODO implement")
+	c := mock.NewMockedContainer()
+	messagesRepo := &messagesfakes.FakeRepository{}
+	messagesRepo.GetMessagesReturns(&messages.MessagePage{}, nil)
+	c.MessagesRepositoryReturns(messagesRepo)
+	tagsRepo := &tagsfakes.FakeRepository{}
+	tagsRepo.GetIDReturns(3, nil)
+	c.TagsRepositoryReturns(tagsRepo)
+
+	rome, err := time.LoadLocation("Europe/Rome")
+	require.Nil(t, err)
+
+	for query, expected := range map[string]messages.Filter{
+		"tag=golang&dateStart=2019-09-01&dateEnd=2019-09-01": {
+			TagID:     3,
+			DateStart: time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC),
+			DateEnd:   time.Date(2019, 9, 2, 0, 0, 0, 0, time.UTC),
+		},
+		"dateStart=2019-09-01&tz=Europe/Rome": {
+			DateStart: time.Date(2019, 9, 1, 0, 0, 0, 0, rome),
+		},
+		"dateEnd=2019-09-01T10:00:00.5%2B02:00": {
+			DateEnd: time.Date(2019, 9, 1, 8, 0, 0, 5e8, time.UTC),
+		},
+	} {
+		messagesRepo.GetMessagesReturns(&messages.MessagePage{}, nil)
+		responseRecorder := doJSONRequest(t, NewRouter(c), "GET", "/v1/messages?"+query, 0, nil)
+		require.Equal(t, http.StatusOK, responseRecorder.Code, query)
+
+		_, filter, _ := messagesRepo.GetMessagesArgsForCall(messagesRepo.GetMessagesCallCount() - 1)
+		require.True(t, expected.DateStart.Equal(filter.DateStart), query)
+		require.True(t, expected.DateEnd.Equal(filter.DateEnd), query)
+		require.Equal(t, expected.TagID, filter.TagID, query)
+	}
+
+	for _, query := range []string{
+		"dateStart=2019-09-01&tz=Mars/Olympus",
+		"dateStart=01/09/2019",
+		"dateEnd=2019-09-01T10:00:00",
+		"dateStart=2019-09-02&dateEnd=2019-09-01",
+		"dateStart=2019-09-01T10:00:00Z&dateEnd=2019-09-01T10:00:00Z",
+	} {
+		responseRecorder := doJSONRequest(t, NewRouter(c), "GET", "/v1/messages?"+query, 0, nil)
+		require.Equal(t, http.StatusBadRequest, responseRecorder.Code, query)
+	}
 }
 
 func TestMessagesRouter_GetMessages_Count(t *testing.T) {
@@ -109,6 +338,317 @@ func TestMessagesRouter_GetMessages_MessagesRepositoryFailure(t *testing.T) {
 	t.Skip("@TODO implement")
 }
 
+func TestMessagesRouter_GetMessage(t *testing.T) {
+	c := mock.NewMockedContainer()
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.MessagesRepositoryReturns(messagesRepo)
+
+	mockedMessage := &messages.MessageList{
+		ID:        789,
+		Message:   "A short message",
+		CreatedAt: "2019-09-01T10:00:00.000Z",
+		UserID:    456,
+		Tags:      []string{"my-test"},
+	}
+	messagesRepo.GetReturns(mockedMessage, nil)
+
+	router := NewRouter(c)
+	request, err := http.NewRequest("GET", "/v1/messages/789", nil)
+	require.Nil(t, err)
+
+	responseRecorder := httptest.NewRecorder()
+	router.ServeHTTP(responseRecorder, request)
+
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+	_, msgID, viewerID := messagesRepo.GetArgsForCall(0)
+	require.EqualValues(t, 789, msgID)
+	require.EqualValues(t, 0, viewerID)
+
+	var body messages.MessageList
+	require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &body))
+	require.Equal(t, *mockedMessage, body)
+}
+
+func TestMessagesRouter_GetMessage_NotFound(t *testing.T) {
+	c := mock.NewMockedContainer()
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.MessagesRepositoryReturns(messagesRepo)
+	messagesRepo.GetReturns(nil, sql.ErrNoRows)
+
+	router := NewRouter(c)
+	request, err := http.NewRequest("GET", "/v1/messages/789", nil)
+	require.Nil(t, err)
+
+	responseRecorder := httptest.NewRecorder()
+	router.ServeHTTP(responseRecorder, request)
+
+	require.Equal(t, http.StatusNotFound, responseRecorder.Code)
+}
+
+func TestMessagesRouter_GetThread(t *testing.T) {
+	c := mock.NewMockedContainer()
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.MessagesRepositoryReturns(messagesRepo)
+	messagesRepo.GetThreadReturns([]messages.ThreadMessage{
+		{MessageList: messages.MessageList{ID: 1, ConversationID: 1, ReplyCount: 1}, Depth: 0},
+		{MessageList: messages.MessageList{ID: 2, ConversationID: 1}, Depth: 1},
+	}, nil)
+
+	router := NewRouter(c)
+	responseRecorder := doJSONRequest(t, router, "GET", "/v1/messages/2/thread", 7, nil)
+
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+	_, msgID, viewerID := messagesRepo.GetThreadArgsForCall(0)
+	require.EqualValues(t, 2, msgID)
+	require.EqualValues(t, 7, viewerID)
+
+	var body []messages.ThreadMessage
+	require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &body))
+	require.Len(t, body, 2)
+	require.Equal(t, 1, body[1].Depth)
+
+	messagesRepo.GetThreadReturns(nil, sql.ErrNoRows)
+	responseRecorder = doJSONRequest(t, router, "GET", "/v1/messages/3/thread", 0, nil)
+	require.Equal(t, http.StatusNotFound, responseRecorder.Code)
+}
+
+func TestMessagesRouter_CreateMessage_ReplyToMissingMessage(t *testing.T) {
+	c := mock.NewMockedContainer()
+	usersRepo := &usersfakes.FakeRepository{}
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.TagsRepositoryReturns(&tagsfakes.FakeRepository{})
+	c.UsersRepositoryReturns(usersRepo)
+	c.MessagesRepositoryReturns(messagesRepo)
+	usersRepo.GetReturns(&users.User{ID: 7}, nil)
+	messagesRepo.CreateReturns(0, messages.ErrParentNotFound)
+
+	router := NewRouter(c)
+	responseRecorder := doJSONRequest(t, router, "POST", "/v1/messages", 7, message{
+		Text:      "A reply",
+		InReplyTo: 42,
+	})
+
+	require.Equal(t, http.StatusBadRequest, responseRecorder.Code)
+	_, created := messagesRepo.CreateArgsForCall(0)
+	require.EqualValues(t, 42, created.InReplyTo)
+}
+
+func TestMessagesRouter_Repost(t *testing.T) {
+	c := mock.NewMockedContainer()
+	usersRepo := &usersfakes.FakeRepository{}
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.UsersRepositoryReturns(usersRepo)
+	c.MessagesRepositoryReturns(messagesRepo)
+	usersRepo.GetReturns(&users.User{ID: 7}, nil)
+	messagesRepo.RepostReturns(43, nil)
+
+	router := NewRouter(c)
+	responseRecorder := doJSONRequest(t, router, "POST", "/v1/messages/42/repost", 7, nil)
+
+	require.Equal(t, http.StatusCreated, responseRecorder.Code)
+	require.Equal(t, "/v1/messages/43", responseRecorder.Header().Get("Location"))
+	_, userID, msgID := messagesRepo.RepostArgsForCall(0)
+	require.EqualValues(t, 7, userID)
+	require.EqualValues(t, 42, msgID)
+
+	messagesRepo.RepostReturns(0, sql.ErrNoRows)
+	responseRecorder = doJSONRequest(t, router, "POST", "/v1/messages/44/repost", 7, nil)
+	require.Equal(t, http.StatusNotFound, responseRecorder.Code)
+
+	responseRecorder = doJSONRequest(t, router, "POST", "/v1/messages/42/repost", 0, nil)
+	require.Equal(t, http.StatusUnauthorized, responseRecorder.Code)
+
+	responseRecorder = doJSONRequest(t, router, "DELETE", "/v1/messages/42/repost", 7, nil)
+	require.Equal(t, http.StatusNoContent, responseRecorder.Code)
+	_, userID, msgID = messagesRepo.UnrepostArgsForCall(0)
+	require.EqualValues(t, 7, userID)
+	require.EqualValues(t, 42, msgID)
+
+	// unknown users can't repost
+	usersRepo.GetReturns(nil, sql.ErrNoRows)
+	for _, method := range []string{"POST", "DELETE"} {
+		responseRecorder = doJSONRequest(t, router, method, "/v1/messages/42/repost", 8, nil)
+		require.Equal(t, http.StatusForbidden, responseRecorder.Code)
+	}
+	require.Equal(t, 2, messagesRepo.RepostCallCount())
+	require.Equal(t, 1, messagesRepo.UnrepostCallCount())
+}
+
+func TestMessagesRouter_CreateMessage_QuoteOfMissingMessage(t *testing.T) {
+	c := mock.NewMockedContainer()
+	usersRepo := &usersfakes.FakeRepository{}
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.TagsRepositoryReturns(&tagsfakes.FakeRepository{})
+	c.UsersRepositoryReturns(usersRepo)
+	c.MessagesRepositoryReturns(messagesRepo)
+	usersRepo.GetReturns(&users.User{ID: 7}, nil)
+	messagesRepo.CreateReturns(0, messages.ErrQuotedNotFound)
+
+	router := NewRouter(c)
+	responseRecorder := doJSONRequest(t, router, "POST", "/v1/messages", 7, message{
+		Text:    "A quote",
+		QuoteOf: 42,
+	})
+
+	require.Equal(t, http.StatusBadRequest, responseRecorder.Code)
+	_, created := messagesRepo.CreateArgsForCall(0)
+	require.EqualValues(t, 42, created.QuoteOf)
+}
+
+func TestMessagesRouter_Like(t *testing.T) {
+	c := mock.NewMockedContainer()
+	usersRepo := &usersfakes.FakeRepository{}
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.UsersRepositoryReturns(usersRepo)
+	c.MessagesRepositoryReturns(messagesRepo)
+	usersRepo.GetReturns(&users.User{ID: 7}, nil)
+
+	router := NewRouter(c)
+	responseRecorder := doJSONRequest(t, router, "PUT", "/v1/messages/42/like", 7, nil)
+	require.Equal(t, http.StatusNoContent, responseRecorder.Code)
+	_, userID, msgID := messagesRepo.LikeArgsForCall(0)
+	require.EqualValues(t, 7, userID)
+	require.EqualValues(t, 42, msgID)
+
+	responseRecorder = doJSONRequest(t, router, "DELETE", "/v1/messages/42/like", 7, nil)
+	require.Equal(t, http.StatusNoContent, responseRecorder.Code)
+	require.Equal(t, 1, messagesRepo.UnlikeCallCount())
+
+	messagesRepo.LikeReturns(sql.ErrNoRows)
+	responseRecorder = doJSONRequest(t, router, "PUT", "/v1/messages/43/like", 7, nil)
+	require.Equal(t, http.StatusNotFound, responseRecorder.Code)
+
+	responseRecorder = doJSONRequest(t, router, "PUT", "/v1/messages/42/like", 0, nil)
+	require.Equal(t, http.StatusUnauthorized, responseRecorder.Code)
+
+	// the likes of unknown users would be counted without being listed
+	usersRepo.GetReturns(nil, sql.ErrNoRows)
+	for _, method := range []string{"PUT", "DELETE"} {
+		responseRecorder = doJSONRequest(t, router, method, "/v1/messages/42/like", 8, nil)
+		require.Equal(t, http.StatusForbidden, responseRecorder.Code)
+	}
+	require.Equal(t, 2, messagesRepo.LikeCallCount())
+	require.Equal(t, 1, messagesRepo.UnlikeCallCount())
+}
+
+func TestMessagesRouter_UpdateMessage(t *testing.T) {
+	c := mock.NewMockedContainer()
+	tagsRepo := &tagsfakes.FakeRepository{}
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.TagsRepositoryReturns(tagsRepo)
+	c.MessagesRepositoryReturns(messagesRepo)
+	tagsRepo.PutManyReturns([]int64{3}, nil)
+	messagesRepo.GetReturns(&messages.MessageList{ID: 42, Message: "Fixed #typo"}, nil)
+
+	router := NewRouter(c)
+	responseRecorder := doJSONRequest(t, router, "PATCH", "/v1/messages/42", 7, messageUpdate{Text: "Fixed #typo"})
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+	_, tagList := tagsRepo.PutManyArgsForCall(0)
+	require.Equal(t, []string{"typo"}, tagList)
+	_, updated := messagesRepo.UpdateArgsForCall(0)
+	require.Equal(t, messages.MessageUpdate{
+		ID:       42,
+		UserID:   7,
+		TagIDs:   []int64{3},
+		Mentions: nil,
+		Message:  "Fixed #typo",
+	}, updated)
+
+	var body messages.MessageList
+	require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &body))
+	require.Equal(t, "Fixed #typo", body.Message)
+
+	_, userID, msgID := messagesRepo.CheckEditableArgsForCall(0)
+	require.EqualValues(t, 7, userID)
+	require.EqualValues(t, 42, msgID)
+
+	for err, code := range map[error]int{
+		sql.ErrNoRows:           http.StatusNotFound,
+		messages.ErrNotAuthor:   http.StatusForbidden,
+		messages.ErrNotEditable: http.StatusConflict,
+	} {
+		messagesRepo.UpdateReturns(err)
+		responseRecorder = doJSONRequest(t, router, "PATCH", "/v1/messages/42", 7, messageUpdate{Text: "Again"})
+		require.Equal(t, code, responseRecorder.Code)
+	}
+
+	// no tags are created for a message that can't be edited
+	putMany := tagsRepo.PutManyCallCount()
+	messagesRepo.CheckEditableReturns(messages.ErrNotAuthor)
+	responseRecorder = doJSONRequest(t, router, "PATCH", "/v1/messages/42", 8, messageUpdate{Text: "Not #mine"})
+	require.Equal(t, http.StatusForbidden, responseRecorder.Code)
+	require.Equal(t, putMany, tagsRepo.PutManyCallCount())
+}
+
+func TestMessagesRouter_DeleteMessage(t *testing.T) {
+	c := mock.NewMockedContainer()
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.MessagesRepositoryReturns(messagesRepo)
+
+	router := NewRouter(c)
+	responseRecorder := doJSONRequest(t, router, "DELETE", "/v1/messages/42", 7, nil)
+	require.Equal(t, http.StatusNoContent, responseRecorder.Code)
+	_, userID, msgID := messagesRepo.DeleteArgsForCall(0)
+	require.EqualValues(t, 7, userID)
+	require.EqualValues(t, 42, msgID)
+
+	messagesRepo.DeleteReturns(messages.ErrNotAuthor)
+	responseRecorder = doJSONRequest(t, router, "DELETE", "/v1/messages/42", 8, nil)
+	require.Equal(t, http.StatusForbidden, responseRecorder.Code)
+
+	responseRecorder = doJSONRequest(t, router, "DELETE", "/v1/messages/42", 0, nil)
+	require.Equal(t, http.StatusUnauthorized, responseRecorder.Code)
+}
+
+func TestMessagesRouter_GetHistory(t *testing.T) {
+	c := mock.NewMockedContainer()
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.MessagesRepositoryReturns(messagesRepo)
+	messagesRepo.GetHistoryReturns([]messages.Revision{
+		{Message: "Helo", CreatedAt: "2019-09-01T10:00:00.000Z"},
+		{Message: "Hello", CreatedAt: "2019-09-01T10:01:00.000Z"},
+	}, nil)
+
+	router := NewRouter(c)
+	responseRecorder := doJSONRequest(t, router, "GET", "/v1/messages/42/history", 0, nil)
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+	_, msgID := messagesRepo.GetHistoryArgsForCall(0)
+	require.EqualValues(t, 42, msgID)
+
+	var body []messages.Revision
+	require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &body))
+	require.Len(t, body, 2)
+	require.Equal(t, "Hello", body[1].Message)
+
+	messagesRepo.GetHistoryReturns(nil, sql.ErrNoRows)
+	responseRecorder = doJSONRequest(t, router, "GET", "/v1/messages/43/history", 0, nil)
+	require.Equal(t, http.StatusNotFound, responseRecorder.Code)
+}
+
+func TestMessagesRouter_DisabledFeatures(t *testing.T) {
+	c := mock.NewMockedContainer()
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.MessagesRepositoryReturns(messagesRepo)
+	cfg := config.Default()
+	cfg.Features = config.Features{}
+	c.ConfigReturns(cfg)
+
+	router := NewRouter(c)
+	for _, route := range [][2]string{
+		{"POST", "/v1/messages/42/repost"},
+		{"PUT", "/v1/messages/42/like"},
+		{"GET", "/v1/messages/42/history"},
+		{"GET", "/v1/users/42/likes"},
+	} {
+		responseRecorder := doJSONRequest(t, router, route[0], route[1], 7, nil)
+		require.Contains(t, []int{http.StatusNotFound, http.StatusMethodNotAllowed}, responseRecorder.Code, route)
+	}
+
+	responseRecorder := doJSONRequest(t, router, "GET", "/v1/messages?q=hello", 0, nil)
+	require.Equal(t, http.StatusNotImplemented, responseRecorder.Code)
+	require.Equal(t, 0, messagesRepo.SearchCallCount())
+}
+
 func getRequestBody(t *testing.T, msg message) *bytes.Buffer {
 	body, err := json.Marshal(msg)
 	require.Nil(t, err)
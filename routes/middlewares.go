@@ -2,11 +2,25 @@ package routes
 
 import (
 	"context"
-	"log"
+	"database/sql"
+	"fmt"
+	"go-twitter-test/logging"
+	"go-twitter-test/metrics"
+	"go-twitter-test/repositories/users"
+	"go-twitter-test/tracing"
+	"log/slog"
 	"net/http"
+	"runtime/debug"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const userIDKey = "CTX_USER_ID"
@@ -19,10 +33,108 @@ type ctxReader struct {
 	request *http.Request
 }
 
-func loggerMiddleware(l *log.Logger) func(next http.Handler) http.Handler {
-	return middleware.RequestLogger(&middleware.DefaultLogFormatter{
-		Logger: l, NoColor: false,
-	})
+// loggerMiddleware puts in the request context a logger carrying the request ID, the user ID and the trace ID (see
+// requestLogger) and writes one access log entry per request once it has been served, at the error level for the 5xx
+func loggerMiddleware(logger *slog.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			l := logger.With("request_id", middleware.GetReqID(r.Context()))
+			if userID, _ := r.Context().Value(userIDKey).(int64); userID != 0 {
+				l = l.With("user_id", userID)
+			}
+			if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+				l = l.With("trace_id", sc.TraceID().String())
+			}
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(logging.NewContext(r.Context(), l)))
+
+			level := slog.LevelInfo
+			if status(ww) >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			l.Log(r.Context(), level, "Request served",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"route", routePattern(r),
+				"status", status(ww),
+				"bytes", ww.BytesWritten(),
+				"duration_ms", float64(time.Since(start).Microseconds())/1000,
+				"remote_addr", r.RemoteAddr,
+				"user_agent", r.UserAgent(),
+			)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// requestLogger returns the logger of the request with the route that served it, see loggerMiddleware
+func requestLogger(r *http.Request) *slog.Logger {
+	return logging.FromContext(r.Context()).With("route", routePattern(r))
+}
+
+// metricsMiddleware records the count and the duration of the requests by route pattern, the pattern is known only
+// once the request has been routed
+func metricsMiddleware(m *metrics.Metrics) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r)
+
+			m.ObserveRequest(routePattern(r), r.Method, status(ww), time.Since(start))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// tracingMiddleware starts the span of the request, continuing the trace of the caller if the request has a W3C
+// traceparent header. The span is named after the route pattern, which is known only once the request has been
+// routed. The handlers pass the request context to the repositories so that their spans are children of this one.
+func tracingMiddleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, "HTTP "+r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", "", r)...),
+		)
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		route := routePattern(r)
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(semconv.HTTPRouteKey.String(route))
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status(ww))...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status(ww), trace.SpanKindServer))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// routePattern returns the pattern of the route that served the request, the unknown paths are grouped together
+// otherwise anybody could create as many time series (or span names) as they want
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		return rctx.RoutePattern()
+	}
+
+	return "unmatched"
+}
+
+// status returns the status code written to ww, net/http sends a 200 when nothing has been written
+func status(ww middleware.WrapResponseWriter) int {
+	if ww.Status() == 0 {
+		return http.StatusOK
+	}
+
+	return ww.Status()
 }
 
 func userMiddleware() func(next http.Handler) http.Handler {
@@ -44,3 +156,138 @@ func userMiddleware() func(next http.Handler) http.Handler {
 		return http.HandlerFunc(fn)
 	}
 }
+
+// callerExists checks that the X-User-ID caller is a registered user, otherwise it renders a 403 (or a 500 when the
+// user can't be read) and returns false, so that the actions of unknown users are not stored
+func callerExists(w http.ResponseWriter, r *http.Request, usersRepository users.Repository, userID int64) bool {
+	if _, err := usersRepository.Get(r.Context(), userID); err != nil {
+		if err == sql.ErrNoRows {
+			RenderError(w, r, "User not found", http.StatusForbidden)
+		} else {
+			RenderError(w, r, "Repository error", http.StatusInternalServerError)
+			requestLogger(r).Error("Could not get user", "error", err)
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// allowContentType is like middleware.AllowContentType but it renders a problem when rejecting a request
+func allowContentType(contentTypes ...string) func(next http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(contentTypes))
+	for _, t := range contentTypes {
+		allowed[strings.ToLower(t)] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength == 0 {
+				// skip check for empty content body
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			s := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Type")))
+			if i := strings.Index(s, ";"); i > -1 {
+				s = s[0:i]
+			}
+
+			if !allowed[s] {
+				RenderError(
+					w, r, "Content-Type must be one of: "+strings.Join(contentTypes, ", "),
+					http.StatusUnsupportedMediaType,
+				)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// recoverer is like middleware.Recoverer but it renders a problem after logging the panic
+func recoverer(logger *slog.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if rvr := recover(); rvr != nil {
+					logger.Error("Panic",
+						"request_id", middleware.GetReqID(r.Context()),
+						"panic", fmt.Sprintf("%+v", rvr),
+						"stack", string(debug.Stack()),
+					)
+
+					RenderError(w, r, "Unexpected error", http.StatusInternalServerError)
+				}
+			}()
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// timeout is like middleware.Timeout but it renders a problem when the deadline is exceeded.
+// The repositories cancel their queries along with the request context, so once the deadline is exceeded the
+// handler returns early with an error: its response is discarded and replaced with a 504.
+func timeout(timeout time.Duration) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			tw := &timeoutWriter{ResponseWriter: w, ctx: ctx}
+			next.ServeHTTP(tw, r.WithContext(ctx))
+
+			if ctx.Err() == context.DeadlineExceeded && !tw.wroteHeader {
+				RenderError(w, r, "The request took too long", http.StatusGatewayTimeout)
+			}
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// timeoutWriter discards the response written after the deadline of ctx has been exceeded, a response that was
+// already being written before the deadline is let through
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	wroteHeader bool
+	discard     bool
+}
+
+func (tw *timeoutWriter) WriteHeader(statusCode int) {
+	if tw.wroteHeader || tw.discard {
+		return
+	}
+	if tw.ctx.Err() == context.DeadlineExceeded {
+		tw.discard = true
+		return
+	}
+
+	tw.wroteHeader = true
+	tw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.WriteHeader(http.StatusOK)
+	if tw.discard {
+		return len(b), nil
+	}
+
+	return tw.ResponseWriter.Write(b)
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	RenderError(w, r, "Resource not found", http.StatusNotFound)
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	RenderError(w, r, "Method "+r.Method+" not allowed", http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,149 @@
+package routes
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-twitter-test/config"
+	"go-twitter-test/container/mock"
+	"go-twitter-test/logging"
+	"go-twitter-test/pagination"
+	"go-twitter-test/repositories/messages"
+	"go-twitter-test/repositories/messages/messagesfakes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	c := mock.NewMockedContainer()
+	messagesRepo := &messagesfakes.FakeRepository{}
+	messagesRepo.GetReturns(nil, sql.ErrNoRows)
+	c.MessagesRepositoryReturns(messagesRepo)
+	router := NewRouter(c)
+
+	request, err := http.NewRequest("GET", "/v1/messages/42", nil)
+	require.Nil(t, err)
+	request.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	router.ServeHTTP(httptest.NewRecorder(), request)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "GET /v1/messages/{id:[0-9]+}", spans[0].Name())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+	require.Equal(t, trace.SpanKindServer, spans[0].SpanKind())
+	require.Contains(t, spans[0].Attributes(), attribute.Int("http.status_code", http.StatusNotFound))
+
+	// the span of the request is the parent of the repository calls
+	ctx, _, _ := messagesRepo.GetArgsForCall(0)
+	require.Equal(t, spans[0].SpanContext().SpanID(), trace.SpanContextFromContext(ctx).SpanID())
+}
+
+func TestLoggerMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	c := mock.NewMockedContainer()
+	c.LoggerReturns(logging.New(&buf, "info"))
+	messagesRepo := &messagesfakes.FakeRepository{}
+	messagesRepo.GetReturns(nil, errors.New("database is locked"))
+	c.MessagesRepositoryReturns(messagesRepo)
+	router := NewRouter(c)
+
+	request, err := http.NewRequest("GET", "/v1/messages/42", nil)
+	require.Nil(t, err)
+	request.Header.Set("X-User-ID", "7")
+	request.Header.Set("User-Agent", "test")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	require.Equal(t, http.StatusInternalServerError, recorder.Code)
+
+	var entries []map[string]interface{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		require.Nil(t, json.Unmarshal(scanner.Bytes(), &entry), scanner.Text())
+		entries = append(entries, entry)
+	}
+	require.Len(t, entries, 2)
+
+	// the entries written by the handlers carry the request fields
+	handler := entries[0]
+	require.Equal(t, "ERROR", handler["level"])
+	require.Equal(t, "Could not get message", handler["msg"])
+	require.Equal(t, "database is locked", handler["error"])
+	require.EqualValues(t, 42, handler["message_id"])
+	require.EqualValues(t, 7, handler["user_id"])
+	require.Equal(t, "/v1/messages/{id:[0-9]+}", handler["route"])
+	require.NotEmpty(t, handler["request_id"])
+
+	access := entries[1]
+	require.Equal(t, "ERROR", access["level"])
+	require.Equal(t, "Request served", access["msg"])
+	require.Equal(t, handler["request_id"], access["request_id"])
+	require.EqualValues(t, 7, access["user_id"])
+	require.Equal(t, "GET", access["method"])
+	require.Equal(t, "/v1/messages/42", access["path"])
+	require.Equal(t, "/v1/messages/{id:[0-9]+}", access["route"])
+	require.EqualValues(t, http.StatusInternalServerError, access["status"])
+	require.EqualValues(t, recorder.Body.Len(), access["bytes"])
+	require.Equal(t, "test", access["user_agent"])
+	require.Contains(t, access, "duration_ms")
+
+	// the successful requests are logged at the info level, which is filtered out by the warn level
+	buf.Reset()
+	c.LoggerReturns(logging.New(&buf, "warn"))
+	messagesRepo.GetReturns(nil, sql.ErrNoRows)
+	NewRouter(c).ServeHTTP(httptest.NewRecorder(), request)
+	require.Empty(t, buf.String())
+}
+
+func TestTimeout(t *testing.T) {
+	c := mock.NewMockedContainer()
+	cfg := config.Default()
+	cfg.HTTP.RequestTimeout = config.Duration(20 * time.Millisecond)
+	c.ConfigReturns(cfg)
+
+	// like the repositories do, the query is interrupted when the request context is done
+	messagesRepo := &messagesfakes.FakeRepository{}
+	messagesRepo.GetMessagesStub = func(
+		ctx context.Context, _ messages.Filter, _ pagination.Page,
+	) (*messages.MessagePage, error) {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("could not get messages: %v", ctx.Err())
+		case <-time.After(5 * time.Second):
+			return &messages.MessagePage{}, nil
+		}
+	}
+	c.MessagesRepositoryReturns(messagesRepo)
+	router := NewRouter(c)
+
+	start := time.Now()
+	responseRecorder := doJSONRequest(t, router, "GET", "/v1/messages", 0, nil)
+	require.True(t, time.Since(start) < time.Second, "the repository call has not been cancelled")
+
+	// the 500 rendered by the handler is replaced
+	require.Equal(t, http.StatusGatewayTimeout, responseRecorder.Code)
+	var problem Problem
+	require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &problem))
+	require.Equal(t, http.StatusGatewayTimeout, problem.Status)
+}
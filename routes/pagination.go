@@ -0,0 +1,69 @@
+package routes
+
+import (
+	"fmt"
+	"go-twitter-test/pagination"
+	"net/http"
+	"strconv"
+)
+
+// page is the envelope used for paginated responses, next and prev are links to the sibling pages (if any)
+type page struct {
+	Data interface{} `json:"data"`
+	Next string      `json:"next,omitempty"`
+	Prev string      `json:"prev,omitempty"`
+}
+
+// parsePage reads the limit and cursor query parameters of a list sorted by creation time
+func parsePage(r *http.Request, codec *pagination.Codec) (pagination.Page, error) {
+	return parseSortedPage(r, codec, "")
+}
+
+// parseSortedPage reads the limit and cursor query parameters of a list sorted by sort (see pagination.Cursor.Sort),
+// cursors issued for a list sorted differently are rejected
+func parseSortedPage(r *http.Request, codec *pagination.Codec, sort string) (pagination.Page, error) {
+	query := r.URL.Query()
+	p := pagination.Page{Limit: pagination.DefaultLimit}
+
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l < 1 || l > pagination.MaxLimit {
+			return p, fmt.Errorf("limit must be a number between 1 and %d", pagination.MaxLimit)
+		}
+
+		p.Limit = l
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		c, err := codec.Decode(cursor)
+		if err != nil || c.Sort != sort {
+			return p, fmt.Errorf("invalid cursor")
+		}
+
+		p.Cursor = c
+	}
+
+	return p, nil
+}
+
+// newPage wraps data in the pagination envelope building the links to the next and previous pages out of the
+// current request URL, so that all the other query parameters (e.g. filters) are retained
+func newPage(r *http.Request, codec *pagination.Codec, data interface{}, limit int, next, prev *pagination.Cursor) *page {
+	return &page{
+		Data: data,
+		Next: pageLink(r, codec, limit, next),
+		Prev: pageLink(r, codec, limit, prev),
+	}
+}
+
+func pageLink(r *http.Request, codec *pagination.Codec, limit int, cursor *pagination.Cursor) string {
+	if cursor == nil {
+		return ""
+	}
+
+	query := r.URL.Query()
+	query.Set("cursor", codec.Encode(*cursor))
+	query.Set("limit", strconv.Itoa(limit))
+
+	return r.URL.Path + "?" + query.Encode()
+}
@@ -15,24 +15,64 @@ func NewRouter(c container.Container) *chi.Mux {
 	router.Use(
 		middleware.RequestID,
 		middleware.RealIP,
+		metricsMiddleware(c.Metrics()),
+		tracingMiddleware,
 		middleware.DefaultCompress,
 		middleware.RedirectSlashes,
-		middleware.Recoverer,
-		middleware.AllowContentType("application/json"),
-		middleware.Timeout(30*time.Second),
-		render.SetContentType(render.ContentTypeJSON),
-		loggerMiddleware(c.Logger()),
+		recoverer(c.Logger()),
+	)
+
+	// mounted routers inherit these, so that every error is rendered as a problem
+	router.NotFound(notFound)
+	router.MethodNotAllowed(methodNotAllowed)
+
+	// the probes and the metrics are scraped very often, they're kept out of the access log and don't need
+	// the API middlewares
+	probes := &probesRouter{container: c}
+	router.Get("/healthz", probes.Healthz)
+	router.Get("/readyz", probes.Readyz)
+	router.Get("/version", probes.Version)
+	router.Method("GET", "/metrics", c.Metrics().Handler())
+
+	router.Mount("/v1", newAPIRouter(c))
+
+	return router
+}
+
+// newAPIRouter returns the router of the versioned API, with its own middlewares
+func newAPIRouter(c container.Container) *chi.Mux {
+	cfg := c.Config()
+	router := chi.NewRouter()
+
+	// the user is read first so that the request logger, and the access log, carry the user ID
+	router.Use(
 		userMiddleware(),
+		loggerMiddleware(c.Logger()),
+		allowContentType("application/json"),
+		timeout(time.Duration(cfg.HTTP.RequestTimeout)),
+		render.SetContentType(render.ContentTypeJSON),
 	)
 
-	router.Route("/v1", func(r chi.Router) {
-		r.Mount("/messages", NewMessagesRouter(
-			c.MessagesRepository(),
-			c.UsersRepository(),
-			c.TagsRepository(),
-			c.Logger(),
-		))
-	})
+	router.Mount("/messages", NewMessagesRouter(
+		c.MessagesRepository(),
+		c.UsersRepository(),
+		c.TagsRepository(),
+		c.CursorCodec(),
+		cfg.Features,
+	))
+	router.Mount("/users", NewUsersRouter(
+		c.UsersRepository(),
+		c.MessagesRepository(),
+		c.CursorCodec(),
+		cfg.Features,
+	))
+	router.Mount("/stats", NewStatsRouter(c.MessagesRepository(), c.TagsRepository()))
+	router.Mount("/tags", NewTagsRouter(c.TagsRepository(), c.CursorCodec(), cfg.Trending))
+	router.Mount("/timeline", NewTimelineRouter(
+		c.MessagesRepository(),
+		c.TagsRepository(),
+		c.CursorCodec(),
+	))
 
 	return router
 }
@@ -0,0 +1,138 @@
+package routes
+
+import (
+	"encoding/csv"
+	"go-twitter-test/repositories/messages"
+	"go-twitter-test/repositories/tags"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// NewStatsRouter returns a router with the stats routes attached
+func NewStatsRouter(messagesRepository messages.Repository, tagsRepository tags.Repository) *chi.Mux {
+	router := chi.NewRouter()
+	msgs := &messagesRouter{
+		messagesRepository: messagesRepository,
+		tagsRepository:     tagsRepository,
+	}
+
+	router.Get("/messages", msgs.GetMessageStats)
+
+	return router
+}
+
+// GetMessageStats returns the number of messages created in every interval of the date range, optionally grouped by
+// tag or by user (limit being the number of keys getting a series of their own). It supports the same filters of
+// GetMessages, the date range being required.
+// The series are returned as JSON or as CSV (format=csv or Accept: text/csv).
+func (mr *messagesRouter) GetMessageStats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	interval := messages.Interval(query.Get("interval"))
+	switch interval {
+	case messages.Hour, messages.Day, messages.Week:
+	default:
+		RenderError(w, r, "interval must be one of hour, day or week", http.StatusBadRequest)
+		return
+	}
+
+	groupBy := messages.GroupBy(query.Get("groupBy"))
+	switch groupBy {
+	case "", messages.GroupByTag, messages.GroupByUser:
+	default:
+		RenderError(w, r, "groupBy must be either tag or user", http.StatusBadRequest)
+		return
+	}
+
+	limit := messages.DefaultSeries
+	if l := query.Get("limit"); l != "" && groupBy != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > messages.MaxSeries {
+			RenderError(
+				w, r, "limit must be a number between 1 and "+strconv.Itoa(messages.MaxSeries), http.StatusBadRequest,
+			)
+			return
+		}
+	}
+
+	format := query.Get("format")
+	if format == "" && strings.Contains(r.Header.Get("Accept"), "text/csv") {
+		format = "csv"
+	}
+	if format != "" && format != "json" && format != "csv" {
+		RenderError(w, r, "format must be either json or csv", http.StatusBadRequest)
+		return
+	}
+
+	filter, loc, ok := mr.parseFilter(w, r)
+	if !ok {
+		return
+	}
+	if filter.DateStart.IsZero() || filter.DateEnd.IsZero() {
+		RenderError(w, r, "dateStart and dateEnd are required", http.StatusBadRequest)
+		return
+	}
+
+	series, err := mr.messagesRepository.GetStats(r.Context(), messages.StatsQuery{
+		Filter:   filter,
+		Interval: interval,
+		Location: loc,
+		GroupBy:  groupBy,
+		Limit:    limit,
+	})
+	if err != nil {
+		if err == messages.ErrTooManyBuckets {
+			RenderError(
+				w, r, "The date range can't span more than "+strconv.Itoa(messages.MaxBuckets)+" intervals",
+				http.StatusBadRequest,
+			)
+		} else {
+			RenderError(w, r, "Could not get message stats", http.StatusInternalServerError)
+			requestLogger(r).Error("Could not get message stats", "error", err)
+		}
+
+		return
+	}
+
+	if format == "csv" {
+		writeStatsCSV(w, groupBy, series)
+		return
+	}
+
+	render.JSON(w, r, &statsResponse{Interval: interval, GroupBy: groupBy, Series: series})
+}
+
+// writeStatsCSV writes one row per point with the start of the bucket, the key of the series (when grouped, empty
+// for the other keys) and the count, e.g. "start,tag,count"
+func writeStatsCSV(w http.ResponseWriter, groupBy messages.GroupBy, series []messages.Series) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+
+	cw := csv.NewWriter(w)
+	header := []string{"start", "count"}
+	if groupBy != "" {
+		header = []string{"start", string(groupBy), "count"}
+	}
+	_ = cw.Write(header)
+
+	for _, s := range series {
+		for _, p := range s.Points {
+			record := []string{p.Start, strconv.FormatInt(p.Count, 10)}
+			if groupBy != "" {
+				record = []string{p.Start, s.Key, strconv.FormatInt(p.Count, 10)}
+			}
+			_ = cw.Write(record)
+		}
+	}
+
+	cw.Flush()
+}
+
+type statsResponse struct {
+	Interval messages.Interval `json:"interval"`
+	GroupBy  messages.GroupBy  `json:"group_by,omitempty"`
+	Series   []messages.Series `json:"series"`
+}
@@ -0,0 +1,110 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"go-twitter-test/container/mock"
+	"go-twitter-test/repositories/messages"
+	"go-twitter-test/repositories/messages/messagesfakes"
+	"go-twitter-test/repositories/tags/tagsfakes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsRouter_GetMessageStats(t *testing.T) {
+	c := mock.NewMockedContainer()
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.MessagesRepositoryReturns(messagesRepo)
+	tagsRepo := &tagsfakes.FakeRepository{}
+	tagsRepo.GetIDReturns(3, nil)
+	c.TagsRepositoryReturns(tagsRepo)
+
+	series := []messages.Series{
+		{Key: "golang", Points: []messages.Point{
+			{Start: "2019-09-01T00:00:00.000+02:00", Count: 2},
+			{Start: "2019-09-02T00:00:00.000+02:00", Count: 0},
+		}},
+		{Key: "rust", Points: []messages.Point{
+			{Start: "2019-09-01T00:00:00.000+02:00", Count: 0},
+			{Start: "2019-09-02T00:00:00.000+02:00", Count: 1},
+		}},
+	}
+	messagesRepo.GetStatsReturns(series, nil)
+
+	const query = "/v1/stats/messages?interval=day&groupBy=tag&dateStart=2019-09-01&dateEnd=2019-09-02" +
+		"&tz=Europe/Rome&tag=golang&limit=2"
+	responseRecorder := doJSONRequest(t, NewRouter(c), "GET", query, 0, nil)
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+
+	var body statsResponse
+	require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &body))
+	require.Equal(t, statsResponse{Interval: messages.Day, GroupBy: messages.GroupByTag, Series: series}, body)
+
+	rome, err := time.LoadLocation("Europe/Rome")
+	require.Nil(t, err)
+	_, q := messagesRepo.GetStatsArgsForCall(0)
+	require.Equal(t, messages.Day, q.Interval)
+	require.Equal(t, messages.GroupByTag, q.GroupBy)
+	require.Equal(t, 2, q.Limit)
+	require.Equal(t, rome, q.Location)
+	require.EqualValues(t, 3, q.Filter.TagID)
+	require.True(t, time.Date(2019, 9, 1, 0, 0, 0, 0, rome).Equal(q.Filter.DateStart))
+	require.True(t, time.Date(2019, 9, 3, 0, 0, 0, 0, rome).Equal(q.Filter.DateEnd))
+
+	// CSV, either with the format parameter or the Accept header
+	request, err := http.NewRequest("GET", query, nil)
+	require.Nil(t, err)
+	request.Header.Set("Accept", "text/csv")
+	responseRecorder = httptest.NewRecorder()
+	NewRouter(c).ServeHTTP(responseRecorder, request)
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+	require.Equal(t, "text/csv; charset=utf-8", responseRecorder.Header().Get("Content-Type"))
+	require.Equal(t, "start,tag,count\n"+
+		"2019-09-01T00:00:00.000+02:00,golang,2\n"+
+		"2019-09-02T00:00:00.000+02:00,golang,0\n"+
+		"2019-09-01T00:00:00.000+02:00,rust,0\n"+
+		"2019-09-02T00:00:00.000+02:00,rust,1\n", responseRecorder.Body.String())
+
+	messagesRepo.GetStatsReturns([]messages.Series{{Points: series[0].Points}}, nil)
+	responseRecorder = doJSONRequest(t, NewRouter(c), "GET", "/v1/stats/messages?interval=day"+
+		"&dateStart=2019-09-01&dateEnd=2019-09-02&format=csv", 0, nil)
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+	require.Equal(t, "start,count\n"+
+		"2019-09-01T00:00:00.000+02:00,2\n"+
+		"2019-09-02T00:00:00.000+02:00,0\n", responseRecorder.Body.String())
+}
+
+func TestStatsRouter_GetMessageStats_InvalidQuery(t *testing.T) {
+	c := mock.NewMockedContainer()
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.MessagesRepositoryReturns(messagesRepo)
+
+	for _, query := range []string{
+		"dateStart=2019-09-01&dateEnd=2019-09-02",
+		"interval=month&dateStart=2019-09-01&dateEnd=2019-09-02",
+		"interval=day&groupBy=country&dateStart=2019-09-01&dateEnd=2019-09-02",
+		"interval=day&dateStart=2019-09-01&dateEnd=2019-09-02&format=xml",
+		"interval=day&dateStart=2019-09-01",
+		"interval=day&dateEnd=2019-09-02",
+		"interval=day&dateStart=2019-09-01&dateEnd=2019-09-02&tz=Nowhere",
+		"interval=day&groupBy=user&dateStart=2019-09-01&dateEnd=2019-09-02&limit=0",
+		"interval=day&groupBy=user&dateStart=2019-09-01&dateEnd=2019-09-02&limit=51",
+	} {
+		responseRecorder := doJSONRequest(t, NewRouter(c), "GET", "/v1/stats/messages?"+query, 0, nil)
+		require.Equal(t, http.StatusBadRequest, responseRecorder.Code, query)
+	}
+	require.Equal(t, 0, messagesRepo.GetStatsCallCount())
+
+	const query = "/v1/stats/messages?interval=hour&dateStart=2019-01-01&dateEnd=2019-12-31"
+	messagesRepo.GetStatsReturns(nil, messages.ErrTooManyBuckets)
+	responseRecorder := doJSONRequest(t, NewRouter(c), "GET", query, 0, nil)
+	require.Equal(t, http.StatusBadRequest, responseRecorder.Code)
+
+	messagesRepo.GetStatsReturns(nil, errors.New("database is locked"))
+	responseRecorder = doJSONRequest(t, NewRouter(c), "GET", query, 0, nil)
+	require.Equal(t, http.StatusInternalServerError, responseRecorder.Code)
+}
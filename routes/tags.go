@@ -0,0 +1,157 @@
+package routes
+
+import (
+	"database/sql"
+	"go-twitter-test/config"
+	"go-twitter-test/pagination"
+	"go-twitter-test/repositories/tags"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// NewTagsRouter returns a router with the tags routes attached
+func NewTagsRouter(
+	tagsRepository tags.Repository,
+	cursorCodec *pagination.Codec,
+	trending config.Trending,
+) *chi.Mux {
+	router := chi.NewRouter()
+	tgs := &tagsRouter{
+		tagsRepository: tagsRepository,
+		cursorCodec:    cursorCodec,
+		trending:       trending,
+	}
+
+	router.Get("/", tgs.GetTags)
+	router.Get("/trending", tgs.GetTrending)
+	router.Get("/autocomplete", tgs.Autocomplete)
+	// the static routes above are matched first, the tags named "trending" and "autocomplete" can't be looked up
+	router.Get("/{tag}", tgs.GetTag)
+
+	return router
+}
+
+type tagsRouter struct {
+	tagsRepository tags.Repository
+	cursorCodec    *pagination.Codec
+	trending       config.Trending
+}
+
+// autocompleteLimit is the number of suggestions returned by Autocomplete when the client doesn't specify one
+const autocompleteLimit = 10
+
+// relatedTagsLimit is the number of related tags returned by GetTag
+const relatedTagsLimit = 10
+
+// GetTags lists the tags used by at least a message, sorted by name (default) or by usage (sort=usage).
+// It's paginated like GetMessages.
+func (tr *tagsRouter) GetTags(w http.ResponseWriter, r *http.Request) {
+	sort := tags.SortByName
+	if s := r.URL.Query().Get("sort"); s != "" {
+		sort = tags.Sort(s)
+	}
+	if sort != tags.SortByName && sort != tags.SortByUsage {
+		RenderError(w, r, "sort must be either name or usage", http.StatusBadRequest)
+		return
+	}
+
+	p, err := parseSortedPage(r, tr.cursorCodec, string(sort))
+	if err != nil {
+		RenderError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	list, err := tr.tagsRepository.List(r.Context(), sort, p)
+	if err != nil {
+		RenderError(w, r, "Could not get tags", http.StatusInternalServerError)
+		requestLogger(r).Error("Could not get tags", "error", err)
+		return
+	}
+
+	render.JSON(w, r, newPage(r, tr.cursorCodec, list.Tags, p.Limit, list.Next, list.Prev))
+}
+
+// Autocomplete suggests the tags starting with the prefix, which is tokenized the same way the tags are.
+// The most used tags come first.
+func (tr *tagsRouter) Autocomplete(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	prefix := query.Get("prefix")
+	if tags.Tokenize(prefix) == "" {
+		RenderError(w, r, "prefix is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := autocompleteLimit
+	if l := query.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > pagination.MaxLimit {
+			RenderError(
+				w, r, "limit must be a number between 1 and "+strconv.Itoa(pagination.MaxLimit),
+				http.StatusBadRequest,
+			)
+			return
+		}
+	}
+
+	suggestions, err := tr.tagsRepository.Autocomplete(r.Context(), prefix, limit)
+	if err != nil {
+		RenderError(w, r, "Could not get tags", http.StatusInternalServerError)
+		requestLogger(r).Error("Could not autocomplete tags", "prefix", prefix, "error", err)
+		return
+	}
+
+	render.JSON(w, r, &page{Data: suggestions})
+}
+
+// GetTag returns how many messages are tagged with the tag, when it was first and last used and the tags used the
+// most along with it
+func (tr *tagsRouter) GetTag(w http.ResponseWriter, r *http.Request) {
+	tag := tags.Tokenize(chi.URLParam(r, "tag"))
+	if !tags.Valid(tag) {
+		RenderError(w, r, "Invalid tag", http.StatusBadRequest)
+		return
+	}
+
+	detail, err := tr.tagsRepository.GetDetail(r.Context(), tag, relatedTagsLimit)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			RenderError(w, r, "Tag not found", http.StatusNotFound)
+		} else {
+			RenderError(w, r, "Could not get tag", http.StatusInternalServerError)
+			requestLogger(r).Error("Could not get tag", "tag", tag, "error", err)
+		}
+
+		return
+	}
+
+	render.JSON(w, r, detail)
+}
+
+// GetTrending lists the tags used more than usual recently, the highest score first. The scores are refreshed in
+// the background (see the trending package) so they can be up to a refresh interval old.
+func (tr *tagsRouter) GetTrending(w http.ResponseWriter, r *http.Request) {
+	limit := tr.trending.Limit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > config.MaxTrendingLimit {
+			RenderError(
+				w, r, "limit must be a number between 1 and "+strconv.Itoa(config.MaxTrendingLimit),
+				http.StatusBadRequest,
+			)
+			return
+		}
+	}
+
+	trending, err := tr.tagsRepository.GetTrending(r.Context(), limit)
+	if err != nil {
+		RenderError(w, r, "Could not get trending tags", http.StatusInternalServerError)
+		requestLogger(r).Error("Could not get trending tags", "error", err)
+		return
+	}
+
+	render.JSON(w, r, &page{Data: trending})
+}
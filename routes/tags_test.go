@@ -0,0 +1,188 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"go-twitter-test/container/mock"
+	"go-twitter-test/pagination"
+	"go-twitter-test/repositories/tags"
+	"go-twitter-test/repositories/tags/tagsfakes"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagsRouter_GetTrending(t *testing.T) {
+	c := mock.NewMockedContainer()
+	tagsRepo := &tagsfakes.FakeRepository{}
+	c.TagsRepositoryReturns(tagsRepo)
+	trending := []tags.TrendingTag{{Tag: "golang", Score: 2.5, Uses: 3}, {Tag: "sqlite", Score: 1.2, Uses: 5}}
+	tagsRepo.GetTrendingReturns(trending, nil)
+
+	responseRecorder := doJSONRequest(t, NewRouter(c), "GET", "/v1/tags/trending", 0, nil)
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+
+	var body struct {
+		Data []tags.TrendingTag `json:"data"`
+	}
+	require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &body))
+	require.Equal(t, trending, body.Data)
+
+	_, limit := tagsRepo.GetTrendingArgsForCall(0)
+	require.Equal(t, c.Config().Trending.Limit, limit)
+
+	responseRecorder = doJSONRequest(t, NewRouter(c), "GET", "/v1/tags/trending?limit=2", 0, nil)
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+	_, limit = tagsRepo.GetTrendingArgsForCall(1)
+	require.Equal(t, 2, limit)
+
+	for _, limit := range []string{"0", "101", "ten"} {
+		responseRecorder = doJSONRequest(t, NewRouter(c), "GET", "/v1/tags/trending?limit="+limit, 0, nil)
+		require.Equal(t, http.StatusBadRequest, responseRecorder.Code, limit)
+	}
+	require.Equal(t, 2, tagsRepo.GetTrendingCallCount())
+
+	tagsRepo.GetTrendingReturns(nil, errors.New("no such table: trending_tags"))
+	responseRecorder = doJSONRequest(t, NewRouter(c), "GET", "/v1/tags/trending", 0, nil)
+	require.Equal(t, http.StatusInternalServerError, responseRecorder.Code)
+}
+
+func TestTagsRouter_GetTags(t *testing.T) {
+	c := mock.NewMockedContainer()
+	tagsRepo := &tagsfakes.FakeRepository{}
+	c.TagsRepositoryReturns(tagsRepo)
+
+	codec := c.CursorCodec()
+	next := &pagination.Cursor{ID: 2, Sort: "usage", Key: "3"}
+	tagsRepo.ListReturns(&tags.TagPage{
+		Tags: []tags.Tag{{Tag: "golang", Uses: 5}, {Tag: "sqlite", Uses: 3}},
+		Next: next,
+	}, nil)
+
+	cursor := pagination.Cursor{ID: 7, Sort: "usage", Key: "5"}
+	responseRecorder := doJSONRequest(
+		t, NewRouter(c), "GET", "/v1/tags?sort=usage&limit=2&cursor="+codec.Encode(cursor), 0, nil,
+	)
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+
+	_, sort, p := tagsRepo.ListArgsForCall(0)
+	require.Equal(t, tags.SortByUsage, sort)
+	require.Equal(t, pagination.Page{Limit: 2, Cursor: &cursor}, p)
+
+	var body struct {
+		Data []tags.Tag `json:"data"`
+		Next string     `json:"next"`
+		Prev string     `json:"prev"`
+	}
+	require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &body))
+	require.Equal(t, []tags.Tag{{Tag: "golang", Uses: 5}, {Tag: "sqlite", Uses: 3}}, body.Data)
+	require.Equal(t, "/v1/tags?cursor="+codec.Encode(*next)+"&limit=2&sort=usage", body.Next)
+	require.Empty(t, body.Prev)
+
+	// sorted by name by default
+	responseRecorder = doJSONRequest(t, NewRouter(c), "GET", "/v1/tags", 0, nil)
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+	_, sort, p = tagsRepo.ListArgsForCall(1)
+	require.Equal(t, tags.SortByName, sort)
+	require.Equal(t, pagination.Page{Limit: pagination.DefaultLimit}, p)
+
+	// the cursors are bound to the sort they were issued for
+	for _, query := range []string{
+		"sort=date",
+		"limit=0",
+		"cursor=forged",
+		"cursor=" + codec.Encode(cursor),
+		"sort=name&cursor=" + codec.Encode(cursor),
+		"sort=usage&cursor=" + codec.Encode(pagination.Cursor{CreatedAt: 1567296000, ID: 7}),
+	} {
+		responseRecorder = doJSONRequest(t, NewRouter(c), "GET", "/v1/tags?"+query, 0, nil)
+		require.Equal(t, http.StatusBadRequest, responseRecorder.Code, query)
+	}
+	require.Equal(t, 2, tagsRepo.ListCallCount())
+
+	tagsRepo.ListReturns(nil, errors.New("database is locked"))
+	responseRecorder = doJSONRequest(t, NewRouter(c), "GET", "/v1/tags", 0, nil)
+	require.Equal(t, http.StatusInternalServerError, responseRecorder.Code)
+}
+
+func TestTagsRouter_Autocomplete(t *testing.T) {
+	c := mock.NewMockedContainer()
+	tagsRepo := &tagsfakes.FakeRepository{}
+	c.TagsRepositoryReturns(tagsRepo)
+	suggestions := []tags.Tag{{Tag: "golang", Uses: 5}, {Tag: "gophers", Uses: 1}}
+	tagsRepo.AutocompleteReturns(suggestions, nil)
+
+	responseRecorder := doJSONRequest(t, NewRouter(c), "GET", "/v1/tags/autocomplete?prefix=Go", 0, nil)
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+
+	var body struct {
+		Data []tags.Tag `json:"data"`
+	}
+	require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &body))
+	require.Equal(t, suggestions, body.Data)
+
+	_, prefix, limit := tagsRepo.AutocompleteArgsForCall(0)
+	require.Equal(t, "Go", prefix)
+	require.Equal(t, autocompleteLimit, limit)
+
+	responseRecorder = doJSONRequest(t, NewRouter(c), "GET", "/v1/tags/autocomplete?prefix=go&limit=3", 0, nil)
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+	_, _, limit = tagsRepo.AutocompleteArgsForCall(1)
+	require.Equal(t, 3, limit)
+
+	for _, query := range []string{"", "prefix=", "prefix=%20%20", "prefix=go&limit=101"} {
+		responseRecorder = doJSONRequest(t, NewRouter(c), "GET", "/v1/tags/autocomplete?"+query, 0, nil)
+		require.Equal(t, http.StatusBadRequest, responseRecorder.Code, query)
+	}
+	require.Equal(t, 2, tagsRepo.AutocompleteCallCount())
+
+	tagsRepo.AutocompleteReturns(nil, errors.New("database is locked"))
+	responseRecorder = doJSONRequest(t, NewRouter(c), "GET", "/v1/tags/autocomplete?prefix=go", 0, nil)
+	require.Equal(t, http.StatusInternalServerError, responseRecorder.Code)
+}
+
+func TestTagsRouter_GetTag(t *testing.T) {
+	c := mock.NewMockedContainer()
+	tagsRepo := &tagsfakes.FakeRepository{}
+	c.TagsRepositoryReturns(tagsRepo)
+	detail := &tags.TagDetail{
+		Tag:       tags.Tag{Tag: "golang", Uses: 5},
+		FirstSeen: "2019-09-01T10:00:00.000Z",
+		LastUsed:  "2019-09-08T12:30:00.000Z",
+		Related:   []tags.Tag{{Tag: "sqlite", Uses: 2}},
+	}
+	tagsRepo.GetDetailReturns(detail, nil)
+
+	responseRecorder := doJSONRequest(t, NewRouter(c), "GET", "/v1/tags/GoLang", 0, nil)
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+	require.JSONEq(t, `{
+		"tag": "golang",
+		"uses": 5,
+		"first_seen": "2019-09-01T10:00:00.000Z",
+		"last_used": "2019-09-08T12:30:00.000Z",
+		"related": [{"tag": "sqlite", "uses": 2}]
+	}`, responseRecorder.Body.String())
+
+	_, tag, related := tagsRepo.GetDetailArgsForCall(0)
+	require.Equal(t, "golang", tag)
+	require.Equal(t, relatedTagsLimit, related)
+
+	// the names of the other routes are reserved
+	responseRecorder = doJSONRequest(t, NewRouter(c), "GET", "/v1/tags/trending", 0, nil)
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+	require.Equal(t, 1, tagsRepo.GetTrendingCallCount())
+
+	responseRecorder = doJSONRequest(t, NewRouter(c), "GET", "/v1/tags/not%20valid!", 0, nil)
+	require.Equal(t, http.StatusBadRequest, responseRecorder.Code)
+	require.Equal(t, 1, tagsRepo.GetDetailCallCount())
+
+	tagsRepo.GetDetailReturns(nil, sql.ErrNoRows)
+	responseRecorder = doJSONRequest(t, NewRouter(c), "GET", "/v1/tags/unknown", 0, nil)
+	require.Equal(t, http.StatusNotFound, responseRecorder.Code)
+
+	tagsRepo.GetDetailReturns(nil, errors.New("database is locked"))
+	responseRecorder = doJSONRequest(t, NewRouter(c), "GET", "/v1/tags/golang", 0, nil)
+	require.Equal(t, http.StatusInternalServerError, responseRecorder.Code)
+}
@@ -0,0 +1,60 @@
+package routes
+
+import (
+	"go-twitter-test/pagination"
+	"go-twitter-test/repositories/messages"
+	"go-twitter-test/repositories/tags"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+// NewTimelineRouter returns a router with the home timeline route attached
+func NewTimelineRouter(
+	messagesRepository messages.Repository,
+	tagsRepository tags.Repository,
+	cursorCodec *pagination.Codec,
+) *chi.Mux {
+	router := chi.NewRouter()
+	msgs := &messagesRouter{
+		messagesRepository: messagesRepository,
+		tagsRepository:     tagsRepository,
+		cursorCodec:        cursorCodec,
+	}
+
+	router.Get("/", msgs.GetTimeline)
+
+	return router
+}
+
+// GetTimeline lists the messages written by the users followed by the caller, it supports the same filters
+// and pagination of GetMessages
+func (mr *messagesRouter) GetTimeline(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(userIDKey).(int64)
+	if userID == 0 {
+		RenderError(w, r, "No user ID provided", http.StatusUnauthorized)
+		return
+	}
+
+	filter, _, ok := mr.parseFilter(w, r)
+	if !ok {
+		return
+	}
+	filter.FollowedBy = userID
+
+	p, err := parsePage(r, mr.cursorCodec)
+	if err != nil {
+		RenderError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	list, err := mr.messagesRepository.GetMessages(r.Context(), filter, p)
+	if err != nil {
+		RenderError(w, r, "Could not get timeline", http.StatusInternalServerError)
+		requestLogger(r).Error("Could not get timeline", "error", err)
+		return
+	}
+
+	render.JSON(w, r, newPage(r, mr.cursorCodec, list.Messages, p.Limit, list.Next, list.Prev))
+}
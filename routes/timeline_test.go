@@ -0,0 +1,39 @@
+package routes
+
+import (
+	"go-twitter-test/container/mock"
+	"go-twitter-test/repositories/messages"
+	"go-twitter-test/repositories/messages/messagesfakes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimelineRouter_GetTimeline(t *testing.T) {
+	c := mock.NewMockedContainer()
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.MessagesRepositoryReturns(messagesRepo)
+	messagesRepo.GetMessagesReturns(&messages.MessagePage{}, nil)
+
+	router := NewRouter(c)
+	responseRecorder := doJSONRequest(t, router, "GET", "/v1/timeline?dateStart=2019-09-01&dateEnd=2019-09-01", 7, nil)
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+
+	_, filter, _ := messagesRepo.GetMessagesArgsForCall(0)
+	require.Equal(t, messages.Filter{
+		DateStart:  time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC),
+		DateEnd:    time.Date(2019, 9, 2, 0, 0, 0, 0, time.UTC),
+		FollowedBy: 7,
+		ViewerID:   7,
+	}, filter)
+}
+
+func TestTimelineRouter_GetTimeline_UserHeaderMissing(t *testing.T) {
+	c := mock.NewMockedContainer()
+
+	router := NewRouter(c)
+	responseRecorder := doJSONRequest(t, router, "GET", "/v1/timeline", 0, nil)
+	require.Equal(t, http.StatusUnauthorized, responseRecorder.Code)
+}
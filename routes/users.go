@@ -0,0 +1,361 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"go-twitter-test/config"
+	"go-twitter-test/pagination"
+	"go-twitter-test/parser"
+	"go-twitter-test/repositories/messages"
+	"go-twitter-test/repositories/users"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+)
+
+// NewUsersRouter returns a router with the users routes attached
+func NewUsersRouter(
+	usersRepository users.Repository,
+	messagesRepository messages.Repository,
+	cursorCodec *pagination.Codec,
+	features config.Features,
+) *chi.Mux {
+	router := chi.NewRouter()
+	usrs := &usersRouter{
+		usersRepository:    usersRepository,
+		messagesRepository: messagesRepository,
+		cursorCodec:        cursorCodec,
+	}
+
+	router.Post("/", usrs.CreateUser)
+	router.Get("/{id:[0-9]+}", usrs.GetUser)
+	router.Patch("/{id:[0-9]+}", usrs.UpdateUser)
+	router.Post("/{id:[0-9]+}/follow", usrs.Follow)
+	router.Delete("/{id:[0-9]+}/follow", usrs.Unfollow)
+	router.Get("/{id:[0-9]+}/followers", usrs.GetFollowers)
+	router.Get("/{id:[0-9]+}/following", usrs.GetFollowing)
+	if features.Likes {
+		router.Get("/{id:[0-9]+}/likes", usrs.GetLikes)
+	}
+
+	return router
+}
+
+type usersRouter struct {
+	usersRepository    users.Repository
+	messagesRepository messages.Repository
+	cursorCodec        *pagination.Codec
+}
+
+func (ur *usersRouter) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var body userCreate
+	if !decodeBody(w, r, &body, "Request body is not a valid user") {
+		return
+	}
+
+	if _, err := mail.ParseAddress(body.Email); err != nil {
+		RenderError(w, r, "Invalid email", http.StatusBadRequest)
+		return
+	}
+	if message := validateProfile(&body.Handle, &body.DisplayName, &body.Bio, &body.AvatarURL); message != "" {
+		RenderError(w, r, message, http.StatusBadRequest)
+		return
+	}
+
+	userID, err := ur.usersRepository.Create(r.Context(), users.UserCreate{
+		Email:       body.Email,
+		Handle:      body.Handle,
+		DisplayName: body.DisplayName,
+		Bio:         body.Bio,
+		AvatarURL:   body.AvatarURL,
+	})
+	if err != nil {
+		switch err {
+		case users.ErrEmailTaken:
+			RenderError(w, r, "Email already taken", http.StatusConflict)
+		case users.ErrHandleTaken:
+			RenderError(w, r, "Handle already taken", http.StatusConflict)
+		default:
+			RenderError(w, r, "Could not create user", http.StatusInternalServerError)
+			requestLogger(r).Error("Could not create user", "error", err)
+		}
+
+		return
+	}
+
+	w.Header().Set("Location", "/v1/users/"+strconv.FormatInt(userID, 10))
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, nil)
+}
+
+func (ur *usersRouter) GetUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		RenderError(w, r, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := ur.usersRepository.Get(r.Context(), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			RenderError(w, r, "User not found", http.StatusNotFound)
+		} else {
+			RenderError(w, r, "Could not get user", http.StatusInternalServerError)
+			requestLogger(r).Error("Could not get user", "target_user_id", userID, "error", err)
+		}
+
+		return
+	}
+
+	render.JSON(w, r, publicUser(*user, r.Context().Value(userIDKey).(int64)))
+}
+
+func (ur *usersRouter) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		RenderError(w, r, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	callerID := r.Context().Value(userIDKey).(int64)
+	if callerID == 0 {
+		RenderError(w, r, "No user ID provided", http.StatusUnauthorized)
+		return
+	}
+	if callerID != userID {
+		RenderError(w, r, "Users can only update their own profile", http.StatusForbidden)
+		return
+	}
+
+	var body userUpdate
+	if !decodeBody(w, r, &body, "Request body is not a valid user update") {
+		return
+	}
+
+	if message := validateProfile(body.Handle, body.DisplayName, body.Bio, body.AvatarURL); message != "" {
+		RenderError(w, r, message, http.StatusBadRequest)
+		return
+	}
+
+	update := users.UserUpdate{
+		Handle:      body.Handle,
+		DisplayName: body.DisplayName,
+		Bio:         body.Bio,
+		AvatarURL:   body.AvatarURL,
+	}
+	if err := ur.usersRepository.Update(r.Context(), userID, update); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			RenderError(w, r, "User not found", http.StatusNotFound)
+		case users.ErrHandleTaken:
+			RenderError(w, r, "Handle already taken", http.StatusConflict)
+		default:
+			RenderError(w, r, "Could not update user", http.StatusInternalServerError)
+			requestLogger(r).Error("Could not update user", "target_user_id", userID, "error", err)
+		}
+
+		return
+	}
+
+	ur.GetUser(w, r)
+}
+
+// Follow makes the caller follow the user, following a user twice is not an error
+func (ur *usersRouter) Follow(w http.ResponseWriter, r *http.Request) {
+	ur.follow(w, r, true)
+}
+
+// Unfollow makes the caller stop following the user, unfollowing a user not followed is not an error
+func (ur *usersRouter) Unfollow(w http.ResponseWriter, r *http.Request) {
+	ur.follow(w, r, false)
+}
+
+func (ur *usersRouter) follow(w http.ResponseWriter, r *http.Request, follow bool) {
+	followeeID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		RenderError(w, r, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	followerID := r.Context().Value(userIDKey).(int64)
+	if followerID == 0 {
+		RenderError(w, r, "No user ID provided", http.StatusUnauthorized)
+		return
+	}
+	if followerID == followeeID {
+		RenderError(w, r, "Users can't follow themselves", http.StatusBadRequest)
+		return
+	}
+	if !callerExists(w, r, ur.usersRepository, followerID) {
+		return
+	}
+
+	if _, err := ur.usersRepository.Get(r.Context(), followeeID); err != nil {
+		if err == sql.ErrNoRows {
+			RenderError(w, r, "User not found", http.StatusNotFound)
+		} else {
+			RenderError(w, r, "Could not get user", http.StatusInternalServerError)
+			requestLogger(r).Error("Could not get user", "target_user_id", followeeID, "error", err)
+		}
+
+		return
+	}
+
+	if follow {
+		err = ur.usersRepository.Follow(r.Context(), followerID, followeeID)
+	} else {
+		err = ur.usersRepository.Unfollow(r.Context(), followerID, followeeID)
+	}
+	if err != nil {
+		RenderError(w, r, "Could not update follows", http.StatusInternalServerError)
+		requestLogger(r).Error("Could not update follows", "target_user_id", followeeID, "follow", follow, "error", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetFollowers lists the users following the user
+func (ur *usersRouter) GetFollowers(w http.ResponseWriter, r *http.Request) {
+	ur.listFollows(w, r, ur.usersRepository.GetFollowers)
+}
+
+// GetFollowing lists the users followed by the user
+func (ur *usersRouter) GetFollowing(w http.ResponseWriter, r *http.Request) {
+	ur.listFollows(w, r, ur.usersRepository.GetFollowing)
+}
+
+func (ur *usersRouter) listFollows(
+	w http.ResponseWriter, r *http.Request,
+	list func(context.Context, int64, pagination.Page) (*users.UserPage, error),
+) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		RenderError(w, r, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	p, err := parsePage(r, ur.cursorCodec)
+	if err != nil {
+		RenderError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := ur.usersRepository.Get(r.Context(), userID); err != nil {
+		if err == sql.ErrNoRows {
+			RenderError(w, r, "User not found", http.StatusNotFound)
+		} else {
+			RenderError(w, r, "Could not get user", http.StatusInternalServerError)
+			requestLogger(r).Error("Could not get user", "target_user_id", userID, "error", err)
+		}
+
+		return
+	}
+
+	result, err := list(r.Context(), userID, p)
+	if err != nil {
+		RenderError(w, r, "Could not get follows", http.StatusInternalServerError)
+		requestLogger(r).Error("Could not get follows", "target_user_id", userID, "error", err)
+		return
+	}
+
+	callerID := r.Context().Value(userIDKey).(int64)
+	for i, user := range result.Users {
+		result.Users[i] = publicUser(user, callerID)
+	}
+
+	render.JSON(w, r, newPage(r, ur.cursorCodec, result.Users, p.Limit, result.Next, result.Prev))
+}
+
+// publicUser leaves the email out of the user returned to anyone but the user themselves
+func publicUser(user users.User, callerID int64) users.User {
+	if user.ID != callerID {
+		user.Email = ""
+	}
+
+	return user
+}
+
+// GetLikes lists the messages liked by the user, it's paginated like GetMessages
+func (ur *usersRouter) GetLikes(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		RenderError(w, r, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	p, err := parsePage(r, ur.cursorCodec)
+	if err != nil {
+		RenderError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := ur.usersRepository.Get(r.Context(), userID); err != nil {
+		if err == sql.ErrNoRows {
+			RenderError(w, r, "User not found", http.StatusNotFound)
+		} else {
+			RenderError(w, r, "Could not get user", http.StatusInternalServerError)
+			requestLogger(r).Error("Could not get user", "target_user_id", userID, "error", err)
+		}
+
+		return
+	}
+
+	filter := messages.Filter{LikedBy: userID, ViewerID: r.Context().Value(userIDKey).(int64)}
+	list, err := ur.messagesRepository.GetMessages(r.Context(), filter, p)
+	if err != nil {
+		RenderError(w, r, "Could not get likes", http.StatusInternalServerError)
+		requestLogger(r).Error("Could not get likes", "target_user_id", userID, "error", err)
+		return
+	}
+
+	render.JSON(w, r, newPage(r, ur.cursorCodec, list.Messages, p.Limit, list.Next, list.Prev))
+}
+
+// validateProfile returns a message describing the first invalid field, nil fields are not validated.
+// The handle is required when not nil since, once set, users can't go back to not having one.
+func validateProfile(handle, displayName, bio, avatarURL *string) string {
+	if handle != nil && !parser.ValidHandle(*handle) {
+		return "Handle must be made of 1 to 15 letters, digits or underscores"
+	}
+	if displayName != nil && utf8.RuneCountInString(*displayName) > maxDisplayNameLength {
+		return "Display name must be at most " + strconv.Itoa(maxDisplayNameLength) + " characters long"
+	}
+	if bio != nil && utf8.RuneCountInString(*bio) > maxBioLength {
+		return "Bio must be at most " + strconv.Itoa(maxBioLength) + " characters long"
+	}
+	if avatarURL != nil && *avatarURL != "" {
+		u, err := url.Parse(*avatarURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "Avatar URL must be an absolute http(s) URL"
+		}
+	}
+
+	return ""
+}
+
+type userCreate struct {
+	Email       string `json:"email"`
+	Handle      string `json:"handle"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url"`
+}
+
+// userUpdate has pointer fields to tell the fields that have been omitted (left untouched) from the empty ones
+type userUpdate struct {
+	Handle      *string `json:"handle"`
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	AvatarURL   *string `json:"avatar_url"`
+}
@@ -0,0 +1,245 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"go-twitter-test/container/mock"
+	"go-twitter-test/repositories/messages"
+	"go-twitter-test/repositories/messages/messagesfakes"
+	"go-twitter-test/repositories/users"
+	"go-twitter-test/repositories/users/usersfakes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsersRouter_CreateUser(t *testing.T) {
+	c := mock.NewMockedContainer()
+	usersRepo := &usersfakes.FakeRepository{}
+	c.UsersRepositoryReturns(usersRepo)
+	usersRepo.CreateReturns(42, nil)
+
+	router := NewRouter(c)
+	responseRecorder := doJSONRequest(t, router, "POST", "/v1/users", 0, userCreate{
+		Email:       "gopher@email.com",
+		Handle:      "gopher",
+		DisplayName: "The Gopher",
+	})
+
+	require.Equal(t, http.StatusCreated, responseRecorder.Code)
+	require.Equal(t, "/v1/users/42", responseRecorder.Header().Get("Location"))
+	_, created := usersRepo.CreateArgsForCall(0)
+	require.Equal(t, users.UserCreate{
+		Email:       "gopher@email.com",
+		Handle:      "gopher",
+		DisplayName: "The Gopher",
+	}, created)
+}
+
+func TestUsersRouter_CreateUser_Invalid(t *testing.T) {
+	c := mock.NewMockedContainer()
+	usersRepo := &usersfakes.FakeRepository{}
+	c.UsersRepositoryReturns(usersRepo)
+
+	router := NewRouter(c)
+	for _, body := range []userCreate{
+		{Email: "not an email", Handle: "gopher"},
+		{Email: "gopher@email.com", Handle: "not a handle"},
+		{Email: "gopher@email.com", Handle: "gopher", AvatarURL: "ftp://avatar.png"},
+	} {
+		responseRecorder := doJSONRequest(t, router, "POST", "/v1/users", 0, body)
+		require.Equal(t, http.StatusBadRequest, responseRecorder.Code, body)
+	}
+
+	require.Equal(t, 0, usersRepo.CreateCallCount())
+}
+
+func TestUsersRouter_CreateUser_EmailTaken(t *testing.T) {
+	c := mock.NewMockedContainer()
+	usersRepo := &usersfakes.FakeRepository{}
+	c.UsersRepositoryReturns(usersRepo)
+	usersRepo.CreateReturns(0, users.ErrEmailTaken)
+
+	router := NewRouter(c)
+	responseRecorder := doJSONRequest(t, router, "POST", "/v1/users", 0, userCreate{
+		Email:  "gopher@email.com",
+		Handle: "gopher",
+	})
+
+	require.Equal(t, http.StatusConflict, responseRecorder.Code)
+}
+
+func TestUsersRouter_GetUser(t *testing.T) {
+	c := mock.NewMockedContainer()
+	usersRepo := &usersfakes.FakeRepository{}
+	c.UsersRepositoryReturns(usersRepo)
+	usersRepo.GetReturns(&users.User{ID: 42, Email: "gopher@email.com", Handle: "gopher"}, nil)
+
+	router := NewRouter(c)
+	for callerID, email := range map[int64]string{0: "", 7: "", 42: "gopher@email.com"} {
+		responseRecorder := doJSONRequest(t, router, "GET", "/v1/users/42", callerID, nil)
+		require.Equal(t, http.StatusOK, responseRecorder.Code)
+
+		// the email is only shown to the user themselves
+		var body users.User
+		require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &body))
+		require.Equal(t, users.User{ID: 42, Email: email, Handle: "gopher"}, body)
+	}
+}
+
+func TestUsersRouter_GetUser_NotFound(t *testing.T) {
+	c := mock.NewMockedContainer()
+	usersRepo := &usersfakes.FakeRepository{}
+	c.UsersRepositoryReturns(usersRepo)
+	usersRepo.GetReturns(nil, sql.ErrNoRows)
+
+	router := NewRouter(c)
+	responseRecorder := doJSONRequest(t, router, "GET", "/v1/users/42", 0, nil)
+
+	require.Equal(t, http.StatusNotFound, responseRecorder.Code)
+}
+
+func TestUsersRouter_UpdateUser(t *testing.T) {
+	c := mock.NewMockedContainer()
+	usersRepo := &usersfakes.FakeRepository{}
+	c.UsersRepositoryReturns(usersRepo)
+	usersRepo.GetReturns(&users.User{ID: 42, Bio: "Digging holes"}, nil)
+
+	router := NewRouter(c)
+	bio := "Digging holes"
+	responseRecorder := doJSONRequest(t, router, "PATCH", "/v1/users/42", 42, userUpdate{Bio: &bio})
+
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+	_, userID, update := usersRepo.UpdateArgsForCall(0)
+	require.EqualValues(t, 42, userID)
+	require.Equal(t, users.UserUpdate{Bio: &bio}, update)
+
+	// users can't update someone else's profile
+	responseRecorder = doJSONRequest(t, router, "PATCH", "/v1/users/42", 7, userUpdate{Bio: &bio})
+	require.Equal(t, http.StatusForbidden, responseRecorder.Code)
+	require.Equal(t, 1, usersRepo.UpdateCallCount())
+}
+
+func TestUsersRouter_Follow(t *testing.T) {
+	c := mock.NewMockedContainer()
+	usersRepo := &usersfakes.FakeRepository{}
+	c.UsersRepositoryReturns(usersRepo)
+	usersRepo.GetReturns(&users.User{ID: 42}, nil)
+
+	router := NewRouter(c)
+	responseRecorder := doJSONRequest(t, router, "POST", "/v1/users/42/follow", 7, nil)
+	require.Equal(t, http.StatusNoContent, responseRecorder.Code)
+
+	_, followerID, followeeID := usersRepo.FollowArgsForCall(0)
+	require.EqualValues(t, 7, followerID)
+	require.EqualValues(t, 42, followeeID)
+
+	responseRecorder = doJSONRequest(t, router, "DELETE", "/v1/users/42/follow", 7, nil)
+	require.Equal(t, http.StatusNoContent, responseRecorder.Code)
+	require.Equal(t, 1, usersRepo.UnfollowCallCount())
+
+	responseRecorder = doJSONRequest(t, router, "POST", "/v1/users/42/follow", 42, nil)
+	require.Equal(t, http.StatusBadRequest, responseRecorder.Code)
+
+	responseRecorder = doJSONRequest(t, router, "POST", "/v1/users/42/follow", 0, nil)
+	require.Equal(t, http.StatusUnauthorized, responseRecorder.Code)
+	require.Equal(t, 1, usersRepo.FollowCallCount())
+
+	// unknown users can't follow anyone
+	usersRepo.GetStub = func(_ context.Context, userID int64) (*users.User, error) {
+		if userID == 8 {
+			return nil, sql.ErrNoRows
+		}
+		return &users.User{ID: userID}, nil
+	}
+	for _, method := range []string{"POST", "DELETE"} {
+		responseRecorder = doJSONRequest(t, router, method, "/v1/users/42/follow", 8, nil)
+		require.Equal(t, http.StatusForbidden, responseRecorder.Code)
+	}
+	require.Equal(t, 1, usersRepo.FollowCallCount())
+	require.Equal(t, 1, usersRepo.UnfollowCallCount())
+}
+
+func TestUsersRouter_GetFollowers(t *testing.T) {
+	c := mock.NewMockedContainer()
+	usersRepo := &usersfakes.FakeRepository{}
+	c.UsersRepositoryReturns(usersRepo)
+	usersRepo.GetReturns(&users.User{ID: 42}, nil)
+	usersRepo.GetFollowersReturns(&users.UserPage{
+		Users: []users.User{{ID: 7, Email: "gopher@email.com", Handle: "gopher"}},
+	}, nil)
+
+	router := NewRouter(c)
+	responseRecorder := doJSONRequest(t, router, "GET", "/v1/users/42/followers?limit=5", 0, nil)
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+
+	_, userID, p := usersRepo.GetFollowersArgsForCall(0)
+	require.EqualValues(t, 42, userID)
+	require.Equal(t, 5, p.Limit)
+
+	var body struct {
+		Data []users.User `json:"data"`
+	}
+	require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &body))
+	require.Equal(t, []users.User{{ID: 7, Handle: "gopher"}}, body.Data)
+}
+
+func TestUsersRouter_GetLikes(t *testing.T) {
+	c := mock.NewMockedContainer()
+	usersRepo := &usersfakes.FakeRepository{}
+	messagesRepo := &messagesfakes.FakeRepository{}
+	c.UsersRepositoryReturns(usersRepo)
+	c.MessagesRepositoryReturns(messagesRepo)
+	usersRepo.GetReturns(&users.User{ID: 42}, nil)
+	messagesRepo.GetMessagesReturns(&messages.MessagePage{
+		Messages: []messages.MessageList{{ID: 3, LikeCount: 1, Liked: true}},
+	}, nil)
+
+	router := NewRouter(c)
+	responseRecorder := doJSONRequest(t, router, "GET", "/v1/users/42/likes?limit=5", 42, nil)
+	require.Equal(t, http.StatusOK, responseRecorder.Code)
+
+	_, filter, p := messagesRepo.GetMessagesArgsForCall(0)
+	require.Equal(t, messages.Filter{LikedBy: 42, ViewerID: 42}, filter)
+	require.Equal(t, 5, p.Limit)
+
+	var body struct {
+		Data []messages.MessageList `json:"data"`
+	}
+	require.Nil(t, json.Unmarshal(responseRecorder.Body.Bytes(), &body))
+	require.Equal(t, []messages.MessageList{{ID: 3, LikeCount: 1, Liked: true}}, body.Data)
+
+	usersRepo.GetReturns(nil, sql.ErrNoRows)
+	responseRecorder = doJSONRequest(t, router, "GET", "/v1/users/43/likes", 0, nil)
+	require.Equal(t, http.StatusNotFound, responseRecorder.Code)
+}
+
+// doJSONRequest serves a request with an optional JSON body, userID is sent as X-User-ID unless it's 0
+func doJSONRequest(t *testing.T, router http.Handler, method, url string, userID int64, body interface{}) *httptest.ResponseRecorder {
+	var reader *bytes.Reader
+	if body != nil {
+		jsonBody, err := json.Marshal(body)
+		require.Nil(t, err)
+		reader = bytes.NewReader(jsonBody)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	request, err := http.NewRequest(method, url, reader)
+	require.Nil(t, err)
+
+	request.Header.Set("Content-Type", "application/json")
+	if userID != 0 {
+		request.Header.Set("X-User-ID", strconv.FormatInt(userID, 10))
+	}
+
+	responseRecorder := httptest.NewRecorder()
+	router.ServeHTTP(responseRecorder, request)
+
+	return responseRecorder
+}
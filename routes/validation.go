@@ -0,0 +1,89 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"go-twitter-test/parser"
+	"go-twitter-test/repositories/tags"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rivo/uniseg"
+)
+
+const (
+	// maxBodySize is the maximum size of a request body in bytes, larger bodies are rejected with a 413
+	maxBodySize = 64 << 10
+	// maxMessageLength is the maximum length of a message text in graphemes (i.e. user-perceived characters)
+	maxMessageLength = 280
+)
+
+// FieldError tells which field of the request body failed the validation and why
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// decodeBody reads the JSON request body into dst, bodies larger than maxBodySize are rejected with a 413.
+// If the body can't be read or decoded an error is rendered and false is returned.
+func decodeBody(w http.ResponseWriter, r *http.Request, dst interface{}, invalidMessage string) bool {
+	jsonData, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			RenderError(
+				w, r, "Request body must be at most "+strconv.Itoa(maxBodySize)+" bytes",
+				http.StatusRequestEntityTooLarge,
+			)
+		} else {
+			RenderError(w, r, "Invalid request body", http.StatusBadRequest)
+		}
+
+		return false
+	}
+
+	if err := json.Unmarshal(jsonData, dst); err != nil {
+		RenderError(w, r, invalidMessage, http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+// validateMessage returns the errors of the text and the tags of a message body, nil if they're valid.
+// The tags are validated once tokenized, the hashtags found in the text included.
+func validateMessage(text string, tagList []string, tag *string) []FieldError {
+	var errs []FieldError
+
+	if strings.TrimSpace(text) == "" {
+		errs = append(errs, FieldError{Field: "text", Message: "Text is required"})
+	} else if uniseg.GraphemeClusterCount(text) > maxMessageLength {
+		errs = append(errs, FieldError{
+			Field:   "text",
+			Message: "Text must be at most " + strconv.Itoa(maxMessageLength) + " characters long",
+		})
+	}
+
+	for _, hashtag := range parser.Hashtags(parser.Parse(text)) {
+		if !tags.Valid(tags.Tokenize(hashtag)) {
+			errs = append(errs, FieldError{Field: "text", Message: "Hashtag #" + hashtag + " is not a valid tag"})
+		}
+	}
+
+	for i, t := range tagList {
+		if !tags.Valid(tags.Tokenize(t)) {
+			errs = append(errs, FieldError{Field: "tags[" + strconv.Itoa(i) + "]", Message: invalidTagMessage})
+		}
+	}
+
+	if tag != nil && !tags.Valid(tags.Tokenize(*tag)) {
+		errs = append(errs, FieldError{Field: "tag", Message: invalidTagMessage})
+	}
+
+	return errs
+}
+
+var invalidTagMessage = "Tag must be made of 1 to " + strconv.Itoa(tags.MaxLength) +
+	" letters, digits, underscores, dashes or spaces"
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go-twitter-test/config"
+	"log/slog"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// listenAndServe runs the HTTP server on the configured address until a SIGINT or a SIGTERM is received (see serve)
+func listenAndServe(cfg config.HTTP, handler http.Handler, logger *slog.Logger) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %v", cfg.Addr, err)
+	}
+
+	return serve(ctx, listener, cfg, handler, logger)
+}
+
+// serve runs the HTTP server on listener until ctx is done (e.g. on a SIGINT or a SIGTERM), then it stops accepting
+// new connections and waits for the in-flight requests to complete, up to the shutdown timeout
+func serve(
+	ctx context.Context, listener net.Listener, cfg config.HTTP, handler http.Handler, logger *slog.Logger,
+) error {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(cfg.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.IdleTimeout),
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		logger.Info("Listening", "addr", listener.Addr().String())
+		errs <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errs:
+		// Serve always returns a non-nil error, e.g. when the listener fails to accept connections
+		return err
+	case <-ctx.Done():
+		logger.Info("Shutting down")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("could not drain in-flight requests: %v", err)
+	}
+
+	return nil
+}
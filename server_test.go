@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"go-twitter-test/config"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServe_Shutdown(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		duration time.Duration
+		drained  bool
+	}{
+		{"in-flight request completed", 200 * time.Millisecond, true},
+		{"in-flight request too slow", 2 * time.Second, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			started := make(chan struct{})
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				time.Sleep(tc.duration)
+				_, _ = w.Write([]byte("done"))
+			})
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			require.Nil(t, err)
+			cfg := config.HTTP{ShutdownTimeout: config.Duration(time.Second)}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			served := make(chan error, 1)
+			go func() { served <- serve(ctx, listener, cfg, handler, logger) }()
+
+			type result struct {
+				body string
+				err  error
+			}
+			responses := make(chan result, 1)
+			go func() {
+				res, err := http.Get("http://" + listener.Addr().String())
+				if err != nil {
+					responses <- result{err: err}
+					return
+				}
+				defer func() { _ = res.Body.Close() }()
+
+				body, err := io.ReadAll(res.Body)
+				responses <- result{body: string(body), err: err}
+			}()
+
+			<-started
+			shutdownStart := time.Now()
+			cancel()
+
+			select {
+			case err = <-served:
+			case <-time.After(5 * time.Second):
+				t.Fatal("serve did not return")
+			}
+			// serve never waits for longer than the shutdown timeout
+			require.Less(t, time.Since(shutdownStart), time.Duration(cfg.ShutdownTimeout)+500*time.Millisecond)
+
+			if !tc.drained {
+				require.NotNil(t, err)
+				return
+			}
+
+			require.Nil(t, err)
+			res := <-responses
+			require.Nil(t, res.err)
+			require.Equal(t, "done", res.body)
+
+			// no new connections are accepted
+			_, err = http.Get("http://" + listener.Addr().String())
+			require.NotNil(t, err)
+		})
+	}
+}
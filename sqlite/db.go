@@ -1,6 +1,7 @@
 package sqlite
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 
@@ -20,70 +21,23 @@ func New(sqliteDsn string) (*sql.DB, error) {
 	return db, nil
 }
 
-const tagsTable = `CREATE TABLE "tags" (
-	id	INTEGER NOT NULL PRIMARY KEY,
-	tag	TEXT UNIQUE
-)`
-
-const usersTable = `CREATE TABLE "users" (
-	id	INTEGER NOT NULL,
-	email	TEXT UNIQUE,
-	PRIMARY KEY(id)
-)`
-
-const messagesTable = `CREATE TABLE "messages" (
-	id	INTEGER NOT NULL,
-	user_id	INTEGER NOT NULL,
-	message	TEXT NOT NULL,
-	created_at	INTEGER NOT NULL,
-	PRIMARY KEY(id)
-)`
-
-const messagesIndexes = `CREATE INDEX user_id_idx ON messages (user_id)`
-
-const messageTagTable = `CREATE TABLE message_tag (
-	message_id	INTEGER NOT NULL,
-	tag_id	INTEGER NOT NULL
-)`
-
-const messageTagIndex1 = `CREATE UNIQUE INDEX message_tag_unique ON message_tag (
-	message_id,
-	tag_id
-)`
-
-const messageTagIndex2 = `CREATE INDEX message_tag_message_id ON message_tag (
-	message_id
-)`
+// HasFTS5 tells whether SQLite has been compiled with the FTS5 extension, which go-sqlite3 enables only when
+// building with the sqlite_fts5 tag (e.g. go build -tags sqlite_fts5)
+func HasFTS5(ctx context.Context, db *sql.DB) (bool, error) {
+	var enabled bool
+	if err := db.QueryRowContext(ctx, "SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return false, fmt.Errorf("could not read sqlite compile options: %v", err)
+	}
 
-const messageTagIndex3 = `CREATE INDEX message_tag_tag_id ON message_tag (
-	tag_id
-)`
+	return enabled, nil
+}
 
-func LoadSchema(db *sql.DB) error {
-	if _, err := db.Exec(tagsTable); err != nil {
-		return fmt.Errorf("could not create tags table: %v", err)
-	}
-	if _, err := db.Exec(usersTable); err != nil {
-		return fmt.Errorf("could not create users table: %v", err)
-	}
-	if _, err := db.Exec(messagesTable); err != nil {
-		return fmt.Errorf("could not create messages table: %v", err)
-	}
-	if _, err := db.Exec(messagesIndexes); err != nil {
-		return fmt.Errorf("could not create messages indexes: %v", err)
-	}
-	if _, err := db.Exec(messageTagTable); err != nil {
-		return fmt.Errorf("could not create message_tag table: %v", err)
-	}
-	if _, err := db.Exec(messageTagIndex1); err != nil {
-		return fmt.Errorf("could not create message_tag index 1: %v", err)
-	}
-	if _, err := db.Exec(messageTagIndex2); err != nil {
-		return fmt.Errorf("could not create message_tag index 2: %v", err)
-	}
-	if _, err := db.Exec(messageTagIndex3); err != nil {
-		return fmt.Errorf("could not create message_tag index 3: %v", err)
+// RollbackTx rolls the transaction back and returns err, wrapped with the rollback error if rolling back fails too.
+// A transaction whose context has been cancelled has already been rolled back by database/sql.
+func RollbackTx(tx *sql.Tx, err error) error {
+	if rollbackErr := tx.Rollback(); rollbackErr != nil && rollbackErr != sql.ErrTxDone {
+		return fmt.Errorf("could not rollback transaction (after %v): %v", err, rollbackErr)
 	}
 
-	return nil
+	return err
 }
@@ -0,0 +1,504 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Migration is a versioned change to the schema, Down must revert what Up does
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// FTS5 migrations are skipped, and not recorded, when SQLite has been compiled without FTS5 so that the schema
+	// is still usable (without full-text search). They're applied as soon as a binary built with FTS5 migrates.
+	FTS5 bool
+}
+
+// Migrations must be sorted by version and never changed once released, add a new migration instead.
+// The first migrations use IF NOT EXISTS so that they can be applied to databases created before the
+// migrations were introduced.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		Up: `CREATE TABLE IF NOT EXISTS "tags" (
+			id	INTEGER NOT NULL PRIMARY KEY,
+			tag	TEXT UNIQUE
+		);
+		CREATE TABLE IF NOT EXISTS "users" (
+			id	INTEGER NOT NULL,
+			email	TEXT UNIQUE,
+			PRIMARY KEY(id)
+		);
+		CREATE TABLE IF NOT EXISTS "messages" (
+			id	INTEGER NOT NULL,
+			user_id	INTEGER NOT NULL,
+			message	TEXT NOT NULL,
+			created_at	INTEGER NOT NULL,
+			PRIMARY KEY(id)
+		);
+		CREATE INDEX IF NOT EXISTS user_id_idx ON messages (user_id);
+		CREATE TABLE IF NOT EXISTS message_tag (
+			message_id	INTEGER NOT NULL,
+			tag_id	INTEGER NOT NULL
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS message_tag_unique ON message_tag (message_id, tag_id);
+		CREATE INDEX IF NOT EXISTS message_tag_message_id ON message_tag (message_id);
+		CREATE INDEX IF NOT EXISTS message_tag_tag_id ON message_tag (tag_id)`,
+		Down: `DROP TABLE message_tag;
+		DROP TABLE messages;
+		DROP TABLE users;
+		DROP TABLE tags`,
+	},
+	{
+		// the mentions table was added to the schema loaded by the tests before the migrations were introduced,
+		// this is what creates it on the databases created by hand in production
+		Version: 2,
+		Name:    "mentions",
+		Up: `CREATE TABLE IF NOT EXISTS mentions (
+			message_id	INTEGER NOT NULL,
+			handle	TEXT NOT NULL
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS mentions_unique ON mentions (message_id, handle);
+		CREATE INDEX IF NOT EXISTS mentions_handle ON mentions (handle)`,
+		Down: `DROP TABLE mentions`,
+	},
+	{
+		// messages_fts is an external content table, the text is not duplicated and it's kept in sync with
+		// the messages table by the triggers
+		Version: 3,
+		Name:    "messages_fts",
+		FTS5:    true,
+		Up: `CREATE VIRTUAL TABLE messages_fts USING fts5(
+			message,
+			content='messages',
+			content_rowid='id'
+		);
+		CREATE TRIGGER messages_fts_insert AFTER INSERT ON messages BEGIN
+			INSERT INTO messages_fts (rowid, message) VALUES (new.id, new.message);
+		END;
+		CREATE TRIGGER messages_fts_delete AFTER DELETE ON messages BEGIN
+			INSERT INTO messages_fts (messages_fts, rowid, message) VALUES ('delete', old.id, old.message);
+		END;
+		CREATE TRIGGER messages_fts_update AFTER UPDATE OF message ON messages BEGIN
+			INSERT INTO messages_fts (messages_fts, rowid, message) VALUES ('delete', old.id, old.message);
+			INSERT INTO messages_fts (rowid, message) VALUES (new.id, new.message);
+		END;
+		INSERT INTO messages_fts (messages_fts) VALUES ('rebuild')`,
+		// IF EXISTS because the migration might have been recorded by a binary built without FTS5, before skipped
+		// migrations stopped being recorded
+		Down: `DROP TRIGGER IF EXISTS messages_fts_update;
+		DROP TRIGGER IF EXISTS messages_fts_delete;
+		DROP TRIGGER IF EXISTS messages_fts_insert;
+		DROP TABLE IF EXISTS messages_fts`,
+	},
+	{
+		Version: 4,
+		Name:    "users_profile",
+		Up: `ALTER TABLE users ADD COLUMN handle TEXT;
+		ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+		ALTER TABLE users ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+		ALTER TABLE users ADD COLUMN avatar_url TEXT NOT NULL DEFAULT '';
+		ALTER TABLE users ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+		CREATE UNIQUE INDEX users_handle ON users (handle COLLATE NOCASE)`,
+		Down: `DROP INDEX users_handle;
+		ALTER TABLE users DROP COLUMN created_at;
+		ALTER TABLE users DROP COLUMN avatar_url;
+		ALTER TABLE users DROP COLUMN bio;
+		ALTER TABLE users DROP COLUMN display_name;
+		ALTER TABLE users DROP COLUMN handle`,
+	},
+	{
+		Version: 5,
+		Name:    "follows",
+		Up: `CREATE TABLE follows (
+			follower_id	INTEGER NOT NULL,
+			followee_id	INTEGER NOT NULL,
+			created_at	INTEGER NOT NULL,
+			PRIMARY KEY(follower_id, followee_id)
+		);
+		CREATE INDEX follows_followee_id ON follows (followee_id)`,
+		Down: `DROP TABLE follows`,
+	},
+	{
+		// conversation_id is the ID of the root message of a thread (a root message points to itself)
+		Version: 6,
+		Name:    "replies",
+		Up: `ALTER TABLE messages ADD COLUMN in_reply_to INTEGER;
+		ALTER TABLE messages ADD COLUMN conversation_id INTEGER;
+		UPDATE messages SET conversation_id = id;
+		CREATE INDEX messages_in_reply_to ON messages (in_reply_to);
+		CREATE INDEX messages_conversation_id ON messages (conversation_id)`,
+		Down: `DROP INDEX messages_conversation_id;
+		DROP INDEX messages_in_reply_to;
+		ALTER TABLE messages DROP COLUMN conversation_id;
+		ALTER TABLE messages DROP COLUMN in_reply_to`,
+	},
+	{
+		// a repost is a message without text pointing to the original one, a user can repost a message only once
+		Version: 7,
+		Name:    "reposts",
+		Up: `ALTER TABLE messages ADD COLUMN repost_of INTEGER;
+		ALTER TABLE messages ADD COLUMN quote_of INTEGER;
+		CREATE UNIQUE INDEX messages_repost_of ON messages (repost_of, user_id) WHERE repost_of IS NOT NULL;
+		CREATE INDEX messages_quote_of ON messages (quote_of) WHERE quote_of IS NOT NULL`,
+		Down: `DROP INDEX messages_quote_of;
+		DROP INDEX messages_repost_of;
+		ALTER TABLE messages DROP COLUMN quote_of;
+		ALTER TABLE messages DROP COLUMN repost_of`,
+	},
+	{
+		Version: 8,
+		Name:    "likes",
+		Up: `CREATE TABLE likes (
+			user_id	INTEGER NOT NULL,
+			message_id	INTEGER NOT NULL,
+			created_at	INTEGER NOT NULL,
+			PRIMARY KEY(user_id, message_id)
+		);
+		CREATE INDEX likes_message_id ON likes (message_id)`,
+		Down: `DROP TABLE likes`,
+	},
+	{
+		// deleted messages are kept (soft delete) so that threads, tags and counts stay consistent,
+		// message_revisions keeps the previous texts of the edited messages along with when they were written
+		Version: 9,
+		Name:    "message_edits",
+		Up: `ALTER TABLE messages ADD COLUMN edited_at INTEGER;
+		ALTER TABLE messages ADD COLUMN deleted_at INTEGER;
+		CREATE TABLE message_revisions (
+			id	INTEGER NOT NULL,
+			message_id	INTEGER NOT NULL,
+			message	TEXT NOT NULL,
+			created_at	INTEGER NOT NULL,
+			PRIMARY KEY(id)
+		);
+		CREATE INDEX message_revisions_message_id ON message_revisions (message_id)`,
+		Down: `DROP TABLE message_revisions;
+		ALTER TABLE messages DROP COLUMN deleted_at;
+		ALTER TABLE messages DROP COLUMN edited_at`,
+	},
+	{
+		// the timestamps of the messages, of their revisions and of the likes were unix seconds, they're now unix
+		// milliseconds; the ones of the users and of the follows are still unix seconds
+		Version: 10,
+		Name:    "millisecond_timestamps",
+		Up: `UPDATE messages SET created_at = created_at * 1000, edited_at = edited_at * 1000,
+			deleted_at = deleted_at * 1000;
+		UPDATE message_revisions SET created_at = created_at * 1000;
+		UPDATE likes SET created_at = created_at * 1000`,
+		Down: `UPDATE likes SET created_at = created_at / 1000;
+		UPDATE message_revisions SET created_at = created_at / 1000;
+		UPDATE messages SET created_at = created_at / 1000, edited_at = edited_at / 1000,
+			deleted_at = deleted_at / 1000`,
+	},
+	{
+		// the messages are selected by creation time to compute their stats (and to score the trending tags)
+		Version: 11,
+		Name:    "messages_created_at",
+		Up:      `CREATE INDEX messages_created_at ON messages (created_at)`,
+		Down:    `DROP INDEX messages_created_at`,
+	},
+	{
+		// trending_tags is refreshed in the background with the scores of the tags used recently, so that reading
+		// them is cheap
+		Version: 12,
+		Name:    "trending_tags",
+		Up: `CREATE TABLE trending_tags (
+			tag_id	INTEGER NOT NULL PRIMARY KEY,
+			score	REAL NOT NULL,
+			uses	INTEGER NOT NULL,
+			refreshed_at	INTEGER NOT NULL
+		);
+		CREATE INDEX trending_tags_score ON trending_tags (score)`,
+		Down: `DROP TABLE trending_tags`,
+	},
+	{
+		// uses is the number of messages (not deleted) tagged with the tag, it's kept up to date by the triggers so
+		// that the tags can be sorted and paginated by usage without counting the uses of all of them
+		Version: 13,
+		Name:    "tags_uses",
+		Up: `ALTER TABLE tags ADD COLUMN uses INTEGER NOT NULL DEFAULT 0;
+		UPDATE tags SET uses = (
+			SELECT COUNT(*) FROM message_tag AS mt
+			INNER JOIN messages AS m ON m.id = mt.message_id
+			WHERE mt.tag_id = tags.id AND m.deleted_at IS NULL
+		);
+		CREATE INDEX tags_uses ON tags (uses DESC, id);
+		CREATE TRIGGER tags_uses_insert AFTER INSERT ON message_tag BEGIN
+			UPDATE tags SET uses = uses + 1 WHERE id = new.tag_id
+				AND EXISTS (SELECT 1 FROM messages WHERE id = new.message_id AND deleted_at IS NULL);
+		END;
+		CREATE TRIGGER tags_uses_delete AFTER DELETE ON message_tag BEGIN
+			UPDATE tags SET uses = uses - 1 WHERE id = old.tag_id
+				AND EXISTS (SELECT 1 FROM messages WHERE id = old.message_id AND deleted_at IS NULL);
+		END;
+		CREATE TRIGGER tags_uses_message_delete AFTER DELETE ON messages WHEN old.deleted_at IS NULL BEGIN
+			UPDATE tags SET uses = uses - 1 WHERE id IN (SELECT tag_id FROM message_tag WHERE message_id = old.id);
+		END;
+		CREATE TRIGGER tags_uses_message_soft_delete AFTER UPDATE OF deleted_at ON messages
+			WHEN (old.deleted_at IS NULL) != (new.deleted_at IS NULL) BEGIN
+			UPDATE tags SET uses = uses + CASE WHEN new.deleted_at IS NULL THEN 1 ELSE -1 END
+				WHERE id IN (SELECT tag_id FROM message_tag WHERE message_id = new.id);
+		END`,
+		Down: `DROP TRIGGER tags_uses_message_soft_delete;
+		DROP TRIGGER tags_uses_message_delete;
+		DROP TRIGGER tags_uses_delete;
+		DROP TRIGGER tags_uses_insert;
+		DROP INDEX tags_uses;
+		ALTER TABLE tags DROP COLUMN uses`,
+	},
+	{
+		// the timestamps of the users and of the follows were left in unix seconds by millisecond_timestamps
+		Version: 14,
+		Name:    "users_millisecond_timestamps",
+		Up: `UPDATE users SET created_at = created_at * 1000;
+		UPDATE follows SET created_at = created_at * 1000`,
+		Down: `UPDATE follows SET created_at = created_at / 1000;
+		UPDATE users SET created_at = created_at / 1000`,
+	},
+}
+
+const migrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version	INTEGER NOT NULL PRIMARY KEY,
+	name	TEXT NOT NULL,
+	applied_at	INTEGER NOT NULL
+)`
+
+// LatestVersion returns the version the schema is at once all the migrations have been applied
+func LatestVersion() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+// ftsTable is the table created by the FTS5 migrations, see CheckFTS5
+const ftsTable = "messages_fts"
+
+// ErrFTS5Required is returned by CheckFTS5 when the schema has full-text search but SQLite hasn't been compiled with
+// FTS5: every write to the messages table would fail since the triggers keeping the index in sync can't run
+var ErrFTS5Required = errors.New("the database has a full-text search index but SQLite has been compiled " +
+	"without FTS5, build with -tags sqlite_fts5")
+
+// CheckFTS5 returns ErrFTS5Required if the schema can't be used by this binary because of FTS5
+func CheckFTS5(ctx context.Context, db *sql.DB) error {
+	fts5, err := HasFTS5(ctx, db)
+	if err != nil || fts5 {
+		return err
+	}
+
+	exists, err := tableExists(ctx, db, ftsTable)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrFTS5Required
+	}
+
+	return nil
+}
+
+// Version returns the version the schema is at: all the migrations up to it have been applied, apart from the FTS5
+// ones when SQLite has been compiled without FTS5 (see Migration.FTS5). It's 0 if none has been applied.
+func Version(ctx context.Context, db *sql.DB) (int, error) {
+	fts5, err := HasFTS5(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	applied, err := appliedVersions(ctx, db, fts5)
+	if err != nil {
+		return 0, err
+	}
+
+	version := 0
+	for _, m := range Migrations {
+		if !applied[m.Version] && (!m.FTS5 || fts5) {
+			break
+		}
+		version = m.Version
+	}
+
+	return version, nil
+}
+
+// appliedVersions returns the versions of the migrations recorded in schema_migrations, none if the table doesn't
+// exist yet. It never changes the schema so that Version can be used by the readiness probe.
+// Binaries built without FTS5 used to record the FTS5 migrations without executing them, those are left out when
+// fts5 is true so that they're applied again.
+func appliedVersions(ctx context.Context, db *sql.DB, fts5 bool) (map[int]bool, error) {
+	applied := make(map[int]bool)
+
+	// it's only created by Migrate and Rollback, the schema of an unmigrated database is left untouched
+	exists, err := tableExists(ctx, db, "schema_migrations")
+	if err != nil || !exists {
+		return applied, err
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("could not get applied migrations: %v", err)
+	}
+
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("could not scan migration row: %v", err)
+		}
+
+		applied[version] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read rows: %v", err)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("could not close rows: %v", err)
+	}
+
+	if fts5 {
+		exists, err := tableExists(ctx, db, ftsTable)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range Migrations {
+			if m.FTS5 && !exists {
+				delete(applied, m.Version)
+			}
+		}
+	}
+
+	return applied, nil
+}
+
+func createMigrationsTable(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, migrationsTable); err != nil {
+		return fmt.Errorf("could not create schema_migrations table: %v", err)
+	}
+
+	return nil
+}
+
+func tableExists(ctx context.Context, db *sql.DB, table string) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = ?", table).
+		Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("could not check if table %s exists: %v", table, err)
+	}
+
+	return exists, nil
+}
+
+// Migrate applies all the pending migrations, each one in its own transaction.
+// It returns the number of migrations applied.
+func Migrate(db *sql.DB) (int, error) {
+	ctx := context.Background()
+	if err := CheckFTS5(ctx, db); err != nil {
+		return 0, err
+	}
+
+	fts5, err := HasFTS5(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := createMigrationsTable(ctx, db); err != nil {
+		return 0, err
+	}
+
+	applied, err := appliedVersions(ctx, db, fts5)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range Migrations {
+		if applied[m.Version] || (m.FTS5 && !fts5) {
+			continue
+		}
+
+		err := inTransaction(db, func(tx *sql.Tx) error {
+			if _, err := tx.Exec(m.Up); err != nil {
+				return err
+			}
+
+			_, err := tx.Exec(
+				"INSERT OR REPLACE INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+				m.Version, m.Name, time.Now().Unix(),
+			)
+			return err
+		})
+		if err != nil {
+			return count, fmt.Errorf("could not apply migration %d (%s): %v", m.Version, m.Name, err)
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+// Rollback reverts the last n applied migrations, latest first.
+// It returns the number of migrations reverted.
+func Rollback(db *sql.DB, n int) (int, error) {
+	ctx := context.Background()
+	if err := CheckFTS5(ctx, db); err != nil {
+		return 0, err
+	}
+
+	if err := createMigrationsTable(ctx, db); err != nil {
+		return 0, err
+	}
+
+	// FTS5 migrations recorded without being executed are reverted as well, their Down uses IF EXISTS
+	applied, err := appliedVersions(ctx, db, false)
+	if err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+	for i := len(Migrations) - 1; i >= 0 && reverted < n; i-- {
+		m := Migrations[i]
+		if !applied[m.Version] {
+			continue
+		}
+
+		err := inTransaction(db, func(tx *sql.Tx) error {
+			if _, err := tx.Exec(m.Down); err != nil {
+				return err
+			}
+
+			_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version)
+			return err
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("could not revert migration %d (%s): %v", m.Version, m.Name, err)
+		}
+
+		reverted++
+	}
+
+	return reverted, nil
+}
+
+func inTransaction(db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %v", err)
+	}
+
+	if err := fn(tx); err != nil {
+		return RollbackTx(tx, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %v", err)
+	}
+
+	return nil
+}
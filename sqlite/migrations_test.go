@@ -0,0 +1,175 @@
+package sqlite
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrations(t *testing.T) {
+	const dbDsn = "./testdata/test1.db"
+	db, err := New(dbDsn)
+	require.Nil(t, err)
+	defer func() {
+		require.Nil(t, db.Close())
+		require.Nil(t, os.Remove(dbDsn))
+	}()
+
+	for i, m := range Migrations {
+		require.Equal(t, i+1, m.Version, "migrations must be sorted and numbered without gaps")
+	}
+
+	// the FTS5 migrations are skipped when SQLite has been compiled without FTS5
+	fts5, err := HasFTS5(context.Background(), db)
+	require.Nil(t, err)
+	expected := len(Migrations)
+	if !fts5 {
+		for _, m := range Migrations {
+			if m.FTS5 {
+				expected--
+			}
+		}
+	}
+
+	// reading the version of an unmigrated database, as the readiness probe does, doesn't change its schema
+	version, err := Version(context.Background(), db)
+	require.Nil(t, err)
+	require.Equal(t, 0, version)
+	exists, err := tableExists(context.Background(), db, "schema_migrations")
+	require.Nil(t, err)
+	require.False(t, exists)
+
+	applied, err := Migrate(db)
+	require.Nil(t, err)
+	require.Equal(t, expected, applied)
+
+	version, err = Version(context.Background(), db)
+	require.Nil(t, err)
+	require.Equal(t, LatestVersion(), version)
+
+	// nothing left to apply
+	applied, err = Migrate(db)
+	require.Nil(t, err)
+	require.Equal(t, 0, applied)
+
+	reverted, err := Rollback(db, 1)
+	require.Nil(t, err)
+	require.Equal(t, 1, reverted)
+
+	version, err = Version(context.Background(), db)
+	require.Nil(t, err)
+	require.Equal(t, LatestVersion()-1, version)
+
+	// every Down must revert its Up, going all the way down and up again proves it
+	reverted, err = Rollback(db, len(Migrations))
+	require.Nil(t, err)
+	require.Equal(t, expected-1, reverted)
+
+	var tables int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name != 'schema_migrations'").
+		Scan(&tables)
+	require.Nil(t, err)
+	require.Equal(t, 0, tables)
+
+	applied, err = Migrate(db)
+	require.Nil(t, err)
+	require.Equal(t, expected, applied)
+}
+
+func TestMigrations_FTS5(t *testing.T) {
+	const dbDsn = "./testdata/test2.db"
+	db, err := New(dbDsn)
+	require.Nil(t, err)
+	defer func() {
+		require.Nil(t, db.Close())
+		require.Nil(t, os.Remove(dbDsn))
+	}()
+
+	fts5, err := HasFTS5(context.Background(), db)
+	require.Nil(t, err)
+
+	_, err = Migrate(db)
+	require.Nil(t, err)
+	require.Nil(t, CheckFTS5(context.Background(), db))
+
+	_, err = db.Exec(`INSERT INTO users (id, email) VALUES (1, 'user@email.com');
+		INSERT INTO messages (id, user_id, message, created_at) VALUES (1, 1, 'Gophers love SQLite', 0)`)
+	require.Nil(t, err)
+
+	// older binaries built without FTS5 recorded the FTS5 migrations without executing them
+	_, err = db.Exec(`DROP TRIGGER IF EXISTS messages_fts_update;
+		DROP TRIGGER IF EXISTS messages_fts_delete;
+		DROP TRIGGER IF EXISTS messages_fts_insert;
+		DROP TABLE IF EXISTS messages_fts;
+		INSERT OR IGNORE INTO schema_migrations (version, name, applied_at) VALUES (3, 'messages_fts', 0)`)
+	require.Nil(t, err)
+
+	version, err := Version(context.Background(), db)
+	require.Nil(t, err)
+	applied, err := Migrate(db)
+	require.Nil(t, err)
+	if !fts5 {
+		require.Equal(t, LatestVersion(), version)
+		require.Equal(t, 0, applied)
+
+		// a database with a full-text search index can't be used without FTS5
+		_, err = db.Exec("CREATE TABLE messages_fts (message TEXT)")
+		require.Nil(t, err)
+		require.Equal(t, ErrFTS5Required, CheckFTS5(context.Background(), db))
+		_, err = Migrate(db)
+		require.Equal(t, ErrFTS5Required, err)
+
+		return
+	}
+
+	// the search index is created as soon as a binary built with FTS5 migrates, the schema isn't up to date till then
+	require.Equal(t, 2, version)
+	require.Equal(t, 1, applied)
+
+	// the existing messages are indexed, counting the rows of messages_fts would read the content table instead
+	var indexed int
+	require.Nil(t, db.QueryRow("SELECT COUNT(*) FROM messages_fts_docsize").Scan(&indexed))
+	require.Equal(t, 1, indexed)
+	var matched int64
+	require.Nil(t, db.QueryRow("SELECT rowid FROM messages_fts WHERE messages_fts MATCH 'gophers'").Scan(&matched))
+	require.EqualValues(t, 1, matched)
+}
+
+func TestMigrations_LegacySchema(t *testing.T) {
+	const dbDsn = "./testdata/test3.db"
+	db, err := New(dbDsn)
+	require.Nil(t, err)
+	defer func() {
+		require.Nil(t, db.Close())
+		require.Nil(t, os.Remove(dbDsn))
+	}()
+
+	// the schema of the databases created before the migrations were introduced, without the mentions
+	_, err = db.Exec(`CREATE TABLE "tags" (id INTEGER NOT NULL PRIMARY KEY, tag TEXT UNIQUE);
+		CREATE TABLE "users" (id INTEGER NOT NULL, email TEXT UNIQUE, PRIMARY KEY(id));
+		CREATE TABLE "messages" (
+			id INTEGER NOT NULL, user_id INTEGER NOT NULL, message TEXT NOT NULL, created_at INTEGER NOT NULL,
+			PRIMARY KEY(id)
+		);
+		CREATE INDEX user_id_idx ON messages (user_id);
+		CREATE TABLE message_tag (message_id INTEGER NOT NULL, tag_id INTEGER NOT NULL);
+		CREATE UNIQUE INDEX message_tag_unique ON message_tag (message_id, tag_id);
+		CREATE INDEX message_tag_message_id ON message_tag (message_id);
+		CREATE INDEX message_tag_tag_id ON message_tag (tag_id);
+		INSERT INTO users (id, email) VALUES (1, 'user@email.com');
+		INSERT INTO messages (id, user_id, message, created_at) VALUES (1, 1, 'hello @gopher', 1567296000)`)
+	require.Nil(t, err)
+
+	_, err = Migrate(db)
+	require.Nil(t, err)
+
+	_, err = db.Exec("INSERT INTO mentions (message_id, handle) VALUES (1, 'gopher')")
+	require.Nil(t, err)
+
+	// the existing rows are kept, the timestamps being migrated to milliseconds
+	var createdAt int64
+	require.Nil(t, db.QueryRow("SELECT created_at FROM messages WHERE id = 1").Scan(&createdAt))
+	require.EqualValues(t, 1567296000000, createdAt)
+}
@@ -0,0 +1,242 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// otlpFileExporter writes the spans in the OTLP file format: every batch is a JSON encoded ExportTraceServiceRequest
+// on its own line (https://opentelemetry.io/docs/specs/otel/protocol/file-exporter/), so that the file can be
+// replayed to any OTLP collector. The JSON mapping of the protobuf messages is written by hand since the OTLP
+// exporters would pull gRPC in.
+type otlpFileExporter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func newOTLPFileExporter(w io.Writer) *otlpFileExporter {
+	return &otlpFileExporter{encoder: json.NewEncoder(w)}
+}
+
+// ExportSpans writes the spans grouped by resource and instrumentation scope, as the OTLP exporters do
+func (e *otlpFileExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	request := otlpRequest{}
+	resources := make(map[attribute.Distinct]int)
+	scopes := make(map[attribute.Distinct]map[instrumentation.Scope]int)
+	for _, span := range spans {
+		key := span.Resource().Equivalent()
+		r, ok := resources[key]
+		if !ok {
+			r = len(request.ResourceSpans)
+			resources[key] = r
+			scopes[key] = make(map[instrumentation.Scope]int)
+			request.ResourceSpans = append(request.ResourceSpans, otlpResourceSpans{
+				Resource:  otlpResource{Attributes: otlpAttributes(span.Resource().Attributes())},
+				SchemaURL: span.Resource().SchemaURL(),
+			})
+		}
+
+		resourceSpans := &request.ResourceSpans[r]
+		s, ok := scopes[key][span.InstrumentationScope()]
+		if !ok {
+			s = len(resourceSpans.ScopeSpans)
+			scopes[key][span.InstrumentationScope()] = s
+			resourceSpans.ScopeSpans = append(resourceSpans.ScopeSpans, otlpScopeSpans{
+				Scope:     otlpScope{Name: span.InstrumentationScope().Name, Version: span.InstrumentationScope().Version},
+				SchemaURL: span.InstrumentationScope().SchemaURL,
+			})
+		}
+
+		resourceSpans.ScopeSpans[s].Spans = append(resourceSpans.ScopeSpans[s].Spans, newOTLPSpan(span))
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.encoder.Encode(request)
+}
+
+// Shutdown doesn't have anything to release, the file is closed by the function returned by Setup
+func (e *otlpFileExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	SchemaURL  string           `json:"schemaUrl,omitempty"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope     otlpScope  `json:"scope"`
+	Spans     []otlpSpan `json:"spans"`
+	SchemaURL string     `json:"schemaUrl,omitempty"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// otlpSpan is the JSON mapping of the OTLP Span message: the IDs are hex encoded, the enums are numbers and the 64
+// bits integers are strings
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Events            []otlpEvent    `json:"events,omitempty"`
+	Links             []otlpLink     `json:"links,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string         `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpLink struct {
+	TraceID    string         `json:"traceId"`
+	SpanID     string         `json:"spanId"`
+	Attributes []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	IntValue    *string         `json:"intValue,omitempty"`
+	DoubleValue *float64        `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+func newOTLPSpan(span sdktrace.ReadOnlySpan) otlpSpan {
+	s := otlpSpan{
+		TraceID: span.SpanContext().TraceID().String(),
+		SpanID:  span.SpanContext().SpanID().String(),
+		Name:    span.Name(),
+		// the values of trace.SpanKind match the ones of the OTLP enum
+		Kind:              int(span.SpanKind()),
+		StartTimeUnixNano: strconv.FormatInt(span.StartTime().UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.EndTime().UnixNano(), 10),
+		Attributes:        otlpAttributes(span.Attributes()),
+		Status:            otlpStatus{Message: span.Status().Description},
+	}
+
+	if span.Parent().HasSpanID() {
+		s.ParentSpanID = span.Parent().SpanID().String()
+	}
+
+	// unlike the ones of the codes package, the OTLP status codes are 0 unset, 1 ok and 2 error
+	switch span.Status().Code {
+	case codes.Ok:
+		s.Status.Code = 1
+	case codes.Error:
+		s.Status.Code = 2
+	}
+
+	for _, event := range span.Events() {
+		s.Events = append(s.Events, otlpEvent{
+			TimeUnixNano: strconv.FormatInt(event.Time.UnixNano(), 10),
+			Name:         event.Name,
+			Attributes:   otlpAttributes(event.Attributes),
+		})
+	}
+
+	for _, link := range span.Links() {
+		s.Links = append(s.Links, otlpLink{
+			TraceID:    link.SpanContext.TraceID().String(),
+			SpanID:     link.SpanContext.SpanID().String(),
+			Attributes: otlpAttributes(link.Attributes),
+		})
+	}
+
+	return s
+}
+
+func otlpAttributes(attributes []attribute.KeyValue) []otlpKeyValue {
+	var keyValues []otlpKeyValue
+	for _, kv := range attributes {
+		keyValues = append(keyValues, otlpKeyValue{Key: string(kv.Key), Value: newOTLPAnyValue(kv.Value)})
+	}
+
+	return keyValues
+}
+
+func newOTLPAnyValue(value attribute.Value) otlpAnyValue {
+	var v otlpAnyValue
+	switch value.Type() {
+	case attribute.BOOL:
+		b := value.AsBool()
+		v.BoolValue = &b
+	case attribute.INT64:
+		i := strconv.FormatInt(value.AsInt64(), 10)
+		v.IntValue = &i
+	case attribute.FLOAT64:
+		f := value.AsFloat64()
+		v.DoubleValue = &f
+	case attribute.BOOLSLICE:
+		v.ArrayValue = &otlpArrayValue{}
+		for _, b := range value.AsBoolSlice() {
+			v.ArrayValue.Values = append(v.ArrayValue.Values, newOTLPAnyValue(attribute.BoolValue(b)))
+		}
+	case attribute.INT64SLICE:
+		v.ArrayValue = &otlpArrayValue{}
+		for _, i := range value.AsInt64Slice() {
+			v.ArrayValue.Values = append(v.ArrayValue.Values, newOTLPAnyValue(attribute.Int64Value(i)))
+		}
+	case attribute.FLOAT64SLICE:
+		v.ArrayValue = &otlpArrayValue{}
+		for _, f := range value.AsFloat64Slice() {
+			v.ArrayValue.Values = append(v.ArrayValue.Values, newOTLPAnyValue(attribute.Float64Value(f)))
+		}
+	case attribute.STRINGSLICE:
+		v.ArrayValue = &otlpArrayValue{}
+		for _, s := range value.AsStringSlice() {
+			v.ArrayValue.Values = append(v.ArrayValue.Values, newOTLPAnyValue(attribute.StringValue(s)))
+		}
+	default:
+		s := value.Emit()
+		v.StringValue = &s
+	}
+
+	return v
+}
@@ -0,0 +1,107 @@
+// Package tracing sets up OpenTelemetry and provides the helpers used to trace the requests and the repository
+// calls. The spans are propagated with the W3C traceparent header.
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go-twitter-test/config"
+	"go-twitter-test/version"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	serviceName         = "go-twitter-test"
+	instrumentationName = "go-twitter-test"
+)
+
+// Setup registers the global tracer provider and propagator, the spans are exported as configured.
+// The returned function flushes the pending spans and releases the exporter, it must be called on shutdown.
+func Setup(cfg config.Tracing) (func(ctx context.Context) error, error) {
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName),
+			semconv.ServiceVersionKey.String(version.Commit),
+		)),
+	}
+
+	// with no exporter the spans are still created, so that the trace context is propagated
+	var file *os.File
+	switch cfg.Exporter {
+	case "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, fmt.Errorf("could not create stdout exporter: %v", err)
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	case "file":
+		var err error
+		file, err = os.OpenFile(cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("could not open tracing file: %v", err)
+		}
+		options = append(options, sdktrace.WithBatcher(newOTLPFileExporter(file)))
+	}
+
+	provider := sdktrace.NewTracerProvider(options...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return func(ctx context.Context) error {
+		if err := provider.Shutdown(ctx); err != nil {
+			return fmt.Errorf("could not shutdown tracer provider: %v", err)
+		}
+		if file != nil {
+			return file.Close()
+		}
+
+		return nil
+	}, nil
+}
+
+// Tracer returns the tracer of the API, from the global tracer provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// StartRepositorySpan starts the span of a repository call, named after the repository and the method
+// (e.g. messages.Get). The SQL statement is added with Statement, the span is ended with End.
+func StartRepositorySpan(ctx context.Context, repository, method string) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, repository+"."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemSqlite,
+			semconv.DBOperationKey.String(method),
+		),
+	)
+}
+
+// Statement records the main SQL statement run by the repository call whose span is in ctx, the whitespace is
+// collapsed so that the multi-line queries are readable once exported
+func Statement(ctx context.Context, statement string) {
+	compacted := strings.Join(strings.Fields(statement), " ")
+	trace.SpanFromContext(ctx).SetAttributes(semconv.DBStatementKey.String(compacted))
+}
+
+// End ends the span, marking it as failed if err is not nil. sql.ErrNoRows is how the repositories tell that
+// something doesn't exist, it isn't a failure.
+func End(span trace.Span, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"go-twitter-test/config"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetup_File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracing")
+	require.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	file := filepath.Join(dir, "spans.json")
+	shutdown, err := Setup(config.Tracing{Exporter: "file", File: file})
+	require.Nil(t, err)
+
+	ctx, span := StartRepositorySpan(context.Background(), "messages", "Get")
+	Statement(ctx, "SELECT id\n\t\tFROM messages\n\t\tWHERE id = ?")
+	End(span, errors.New("some error"))
+
+	_, span = StartRepositorySpan(context.Background(), "messages", "GetThread")
+	End(span, sql.ErrNoRows)
+
+	// the spans are exported in batches, shutting down flushes them
+	require.Nil(t, shutdown(context.Background()))
+
+	data, err := ioutil.ReadFile(file)
+	require.Nil(t, err)
+
+	// one OTLP ExportTraceServiceRequest per batch, both spans are in the same one
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 1)
+
+	var request otlpRequest
+	require.Nil(t, json.Unmarshal([]byte(lines[0]), &request))
+	require.Len(t, request.ResourceSpans, 1)
+	require.Contains(t, request.ResourceSpans[0].Resource.Attributes, otlpKeyValue{
+		Key:   "service.name",
+		Value: otlpAnyValue{StringValue: stringPtr(serviceName)},
+	})
+	require.Len(t, request.ResourceSpans[0].ScopeSpans, 1)
+	require.Equal(t, instrumentationName, request.ResourceSpans[0].ScopeSpans[0].Scope.Name)
+
+	spans := request.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	require.Equal(t, "messages.Get", spans[0].Name)
+	require.Len(t, spans[0].TraceID, 32)
+	require.Len(t, spans[0].SpanID, 16)
+	require.Equal(t, 3, spans[0].Kind, "client")
+	require.Equal(t, otlpStatus{Code: 2, Message: "some error"}, spans[0].Status)
+	require.Equal(t, []otlpKeyValue{
+		{Key: "db.system", Value: otlpAnyValue{StringValue: stringPtr("sqlite")}},
+		{Key: "db.operation", Value: otlpAnyValue{StringValue: stringPtr("Get")}},
+		{Key: "db.statement", Value: otlpAnyValue{StringValue: stringPtr("SELECT id FROM messages WHERE id = ?")}},
+	}, spans[0].Attributes)
+	require.Len(t, spans[0].Events, 1, "the error is recorded as an event")
+	require.Equal(t, "messages.GetThread", spans[1].Name)
+	require.Equal(t, otlpStatus{}, spans[1].Status)
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestSetup_InvalidFile(t *testing.T) {
+	_, err := Setup(config.Tracing{Exporter: "file", File: "/non/existent/dir/spans.json"})
+	require.NotNil(t, err)
+}
@@ -0,0 +1,71 @@
+// Package trending keeps the trending tags up to date in the background, so that GET /v1/tags/trending only has
+// to read the scores computed by the last refresh (see tags.Repository.RefreshTrending)
+package trending
+
+import (
+	"context"
+	"go-twitter-test/config"
+	"go-twitter-test/repositories/tags"
+	"log/slog"
+	"time"
+)
+
+// Worker refreshes the trending tags periodically until it's stopped
+type Worker struct {
+	repo   tags.Repository
+	cfg    config.Trending
+	logger *slog.Logger
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Start refreshes the trending tags right away and then every cfg.RefreshInterval, in its own goroutine.
+// A failed refresh is logged and the trending tags of the previous one are kept.
+func Start(repo tags.Repository, cfg config.Trending, logger *slog.Logger) *Worker {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &Worker{repo: repo, cfg: cfg, logger: logger, cancel: cancel, done: make(chan struct{})}
+
+	go w.run(ctx)
+
+	return w
+}
+
+// Stop interrupts the refresh in progress, if any, and waits for the worker to return
+func (w *Worker) Stop() {
+	w.cancel()
+	<-w.done
+}
+
+func (w *Worker) run(ctx context.Context) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(time.Duration(w.cfg.RefreshInterval))
+	defer ticker.Stop()
+
+	for {
+		w.refresh(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) refresh(ctx context.Context) {
+	// a refresh taking longer than the interval would only delay the next one
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(w.cfg.RefreshInterval))
+	defer cancel()
+
+	start := time.Now()
+	err := w.repo.RefreshTrending(ctx, start, time.Duration(w.cfg.Window), time.Duration(w.cfg.Baseline))
+	if err != nil {
+		if ctx.Err() != context.Canceled {
+			w.logger.Error("Could not refresh trending tags", "error", err)
+		}
+		return
+	}
+
+	w.logger.Debug("Trending tags refreshed", "duration_ms", float64(time.Since(start).Microseconds())/1000)
+}
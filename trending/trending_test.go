@@ -0,0 +1,45 @@
+package trending
+
+import (
+	"context"
+	"go-twitter-test/config"
+	"go-twitter-test/repositories/tags/tagsfakes"
+	"io/ioutil"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorker(t *testing.T) {
+	repo := &tagsfakes.FakeRepository{}
+	refreshed := make(chan struct{}, 10)
+	repo.RefreshTrendingStub = func(ctx context.Context, now time.Time, window, baseline time.Duration) error {
+		refreshed <- struct{}{}
+		return nil
+	}
+
+	cfg := config.Default().Trending
+	cfg.RefreshInterval = config.Duration(10 * time.Millisecond)
+	w := Start(repo, cfg, slog.New(slog.NewJSONHandler(ioutil.Discard, nil)))
+
+	// refreshed right away and then periodically
+	for i := 0; i < 2; i++ {
+		select {
+		case <-refreshed:
+		case <-time.After(time.Second):
+			t.Fatal("trending tags not refreshed")
+		}
+	}
+
+	w.Stop()
+	calls := repo.RefreshTrendingCallCount()
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, calls, repo.RefreshTrendingCallCount(), "no refresh once stopped")
+
+	_, now, window, baseline := repo.RefreshTrendingArgsForCall(0)
+	require.WithinDuration(t, time.Now(), now, time.Second)
+	require.Equal(t, 24*time.Hour, window)
+	require.Equal(t, 7*24*time.Hour, baseline)
+}
@@ -0,0 +1,12 @@
+// Package version holds the build information of the binary, set at build time with:
+//
+//	go build -ldflags "-X go-twitter-test/version.Commit=$(git rev-parse --short HEAD) \
+//		-X go-twitter-test/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+var (
+	// Commit is the git SHA the binary has been built from
+	Commit = "unknown"
+	// BuildTime is when the binary has been built, in RFC 3339 format
+	BuildTime = "unknown"
+)